@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// Returned for an apply request that was replaced by a newer one before it finished, see queueApply.
+var errApplySuperseded = errors.New("superseded by a newer wallpaper request")
+
+// A request to apply wallpapers, run by the apply worker.
+// Its context is created when it is queued, so it can be cancelled before the worker starts it.
+type applyRequest struct {
+	description string
+	apply       func(ctx context.Context) error
+	result      chan error
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+// Holds at most one pending request, as only the latest one is applied.
+var applyRequests = make(chan applyRequest, 1)
+
+// Guards applyRequests and cancelApplying, so queueing a request and cancelling the running one happens at once.
+var applyQueueMutex sync.Mutex
+
+// Held by the apply worker while it runs a request, so Config is not replaced in the middle of it, see controlReload.
+var applyRunningMutex sync.Mutex
+
+// Cancels the context of the latest queued request, which the apply worker is running or about to run.
+var cancelApplying context.CancelFunc = func() {}
+
+var startApplyWorkerOnce sync.Once
+
+// Queues a request to apply wallpapers, which is run by a single worker goroutine, so only one apply runs at a time.
+//
+// Only the latest request is kept: a request still waiting in the queue is dropped, and the context of the running one
+// is cancelled, which stops its post-processing. Both of them get errApplySuperseded as their result.
+// The request works from a copy of Config taken now, see applyConfig; it must not be called while holding controlMutex.
+//
+// Returns a channel that receives the result of the request once it is done; it does not need to be read.
+func queueApply(description string, apply func(ctx context.Context) error) <-chan error {
+	startApplyWorkerOnce.Do(func() {
+		go runApplyWorker()
+	})
+
+	// the worker may run the request much later, and Config may change in the meantime, see applyConfig
+	controlMutex.Lock()
+	snapshot := cloneConfig(Config)
+	controlMutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), configSnapshotKey{}, snapshot))
+	request := applyRequest{
+		description: description,
+		apply:       apply,
+		result:      make(chan error, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	applyQueueMutex.Lock()
+	defer applyQueueMutex.Unlock()
+
+	select {
+	case pending := <-applyRequests:
+		log.Printf("Dropping queued request (%s) in favor of %s", pending.description, description)
+		pending.result <- errApplySuperseded
+	default:
+	}
+	cancelApplying()
+	cancelApplying = cancel
+	applyRequests <- request
+
+	return request.result
+}
+
+// Runs the queued apply requests one after another, see queueApply.
+//
+// Meant to be run as a goroutine.
+func runApplyWorker() {
+	for request := range applyRequests {
+		log.Printf("Running request: %s", request.description)
+		applyRunningMutex.Lock()
+		err := request.apply(request.ctx)
+		applyRunningMutex.Unlock()
+		if errors.Is(err, context.Canceled) {
+			err = errApplySuperseded
+		}
+		request.cancel()
+
+		if errors.Is(err, errApplySuperseded) {
+			log.Printf("Request (%s) was superseded by a newer one", request.description)
+		} else if err != nil {
+			log.Printf("Request (%s) failed: %v", request.description, err)
+		}
+		request.result <- err
+	}
+}
+
+type configSnapshotKey struct{}
+
+// Returns the copy of Config taken when the request of ctx was queued, see queueApply, or Config itself outside of a request.
+//
+// The apply functions read the wallpapers to apply and their settings from it, as Config is changed by other goroutines,
+// e.g. the control methods of the daemon. Their results are written to Config with runStateUpdate.
+func applyConfig(ctx context.Context) *ConfigStruct {
+	if config, ok := ctx.Value(configSnapshotKey{}).(*ConfigStruct); ok {
+		return config
+	}
+	return Config
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// How often watchEngineLogForFailures checks whether the engine already exited during the detection window.
+var BrokenDetectionPollInterval = 500 * time.Millisecond
+
+// Returns the compiled Config.BrokenDetection.Patterns, skipping (and logging) invalid ones.
+func compileBrokenPatterns() []*regexp.Regexp {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range Config.BrokenDetection.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Ignoring invalid broken detection pattern %q: %v", pattern, err)
+			continue
+		}
+		patterns = append(patterns, compiled)
+	}
+	return patterns
+}
+
+// Returns the first line of the engine log that matches one of the patterns.
+// The command line written at the top of the log by startLoggedEngine is skipped.
+func findFailureInLog(logPath string, patterns []*regexp.Regexp) (string, bool) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		log.Printf("Failed to open engine log %s: %v", logPath, err)
+		return "", false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "# ") {
+			continue
+		}
+		for _, pattern := range patterns {
+			if pattern.MatchString(line) {
+				return line, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Watches a newly started engine for Config.BrokenDetection.Window seconds (or until it exits, if that is sooner),
+// then marks the wallpaper as broken if its log matches one of Config.BrokenDetection.Patterns.
+func watchEngineLogForFailures(wallpaperId string, logPath string, pid int) {
+	if !Config.BrokenDetection.Enabled || logPath == "" {
+		return
+	}
+
+	deadline := time.Now().Add(time.Duration(Config.BrokenDetection.Window) * time.Second)
+	for time.Now().Before(deadline) && isProcessRunning(pid) {
+		time.Sleep(BrokenDetectionPollInterval)
+	}
+
+	if line, found := findFailureInLog(logPath, compileBrokenPatterns()); found {
+		log.Printf("Engine log of wallpaper %s matches a failure pattern: %s", wallpaperId, line)
+		markWallpaperBroken(wallpaperId, "linux-wallpaperengine reported: "+line)
+	}
+}
+
+// Returns the reason to mark a wallpaper as broken, if the engine started by applying it exited non-zero
+// (or crashed) within Config.BrokenDetection.Window seconds, see isEngineCrash. The reason includes the exit status.
+//
+// Only meant for the first run of an engine, not for the restarts of the supervisor, see superviseEngine.
+func detectEarlyExit(state *os.ProcessState, runtime time.Duration) (string, bool) {
+	if !Config.BrokenDetection.Enabled || !isEngineCrash(state) {
+		return "", false
+	}
+	if runtime > time.Duration(Config.BrokenDetection.Window)*time.Second {
+		return "", false
+	}
+	return fmt.Sprintf("linux-wallpaperengine exited (%v) %v after being applied", state, runtime.Round(time.Second)), true
+}
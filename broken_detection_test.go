@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs the shell script and returns the state it exited with.
+func runShell(t *testing.T, script string) *os.ProcessState {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Run()
+	if cmd.ProcessState == nil {
+		t.Fatalf("failed to run %q", script)
+	}
+	return cmd.ProcessState
+}
+
+func TestDetectEarlyExit(t *testing.T) {
+	Config = NewDefaultConfig("")
+	Config.BrokenDetection.Window = 10
+
+	tests := []struct {
+		name       string
+		script     string
+		runtime    time.Duration
+		disabled   bool
+		wantBroken bool
+		wantReason string
+	}{
+		{name: "non-zero exit within the window", script: "exit 3", runtime: 2 * time.Second, wantBroken: true, wantReason: "exit status 3"},
+		{name: "crash within the window", script: "kill -SEGV $$", runtime: time.Second, wantBroken: true, wantReason: "segmentation fault"},
+		{name: "normal exit", script: "exit 0", runtime: time.Second},
+		{name: "stopped with SIGTERM", script: "kill -TERM $$", runtime: time.Second},
+		{name: "non-zero exit after the window", script: "exit 1", runtime: 11 * time.Second},
+		{name: "detection disabled", script: "exit 1", runtime: time.Second, disabled: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			Config.BrokenDetection.Enabled = !test.disabled
+
+			reason, broken := detectEarlyExit(runShell(t, test.script), test.runtime)
+			if broken != test.wantBroken {
+				t.Fatalf("got broken %v (%q), want %v", broken, reason, test.wantBroken)
+			}
+			if !strings.Contains(reason, test.wantReason) {
+				t.Errorf("got reason %q, want it to contain %q", reason, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestDefaultBrokenPatterns(t *testing.T) {
+	Config = NewDefaultConfig("")
+	patterns := compileBrokenPatterns()
+
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{line: "File not found: materials/effects/shine.json", want: true},
+		{line: "ERROR: Texture not found: materials/workshop/123/background.tex", want: true},
+		{line: "Cannot find file scene.json in assets", want: true},
+		{line: "Shader compilation failed for effects/blur/shaders/blur.frag", want: true},
+		{line: "Unsupported wallpaper type: application", want: true},
+		{line: "Optional texture not found, using the fallback", want: false},
+		{line: "Loading texture materials/background.tex", want: false},
+		{line: "# linux-wallpaperengine --bg /path/to/file not found: anything", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			logPath := filepath.Join(t.TempDir(), "engine.log")
+			if err := os.WriteFile(logPath, []byte(test.line+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, found := findFailureInLog(logPath, patterns); found != test.want {
+				t.Errorf("got match %v, want %v", found, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EngineCapabilities struct {
+	BinaryPath string    `json:"binary_path"`
+	ModTime    time.Time `json:"mod_time"`
+	Options    []string  `json:"options"`
+}
+
+// How long to wait for `linux-wallpaperengine --help` before giving up on detecting the supported options.
+var EngineHelpTimeout = 5 * time.Second
+
+var engineCapabilities *EngineCapabilities = nil
+var engineCapabilitiesMutex sync.Mutex
+
+// The last failed detection, so the same binary is not run with --help again until it changes.
+type engineCapabilitiesFailure struct {
+	binaryPath string
+	modTime    time.Time
+	err        error
+}
+
+var engineCapabilitiesFailed *engineCapabilitiesFailure = nil
+
+var helpOptionRegex = regexp.MustCompile(`(?:^|[\s,\[])(--[A-Za-z0-9][A-Za-z0-9-]*)`)
+
+// Returns Config.Constants.LinuxWallpaperEngineBin with a leading ~/ expanded to the home directory.
+// The binary is run without a shell, so it is not expanded otherwise.
+func engineBinary() string {
+	binary := Config.Constants.LinuxWallpaperEngineBin
+	if strings.HasPrefix(binary, "~/") {
+		if resolved, err := resolvePath(binary); err == nil {
+			return resolved
+		}
+	}
+	return binary
+}
+
+// Parses the long options (e.g. --fps) mentioned in the output of `linux-wallpaperengine --help`.
+// Returns the options sorted and without duplicates.
+func parseHelpOptions(output []byte) []string {
+	options := []string{}
+	for _, match := range helpOptionRegex.FindAllSubmatch(output, -1) {
+		options = append(options, string(match[1]))
+	}
+	slices.Sort(options)
+	return slices.Compact(options)
+}
+
+// Returns the options supported by Config.Constants.LinuxWallpaperEngineBin.
+//
+// The binary is only run with --help once per path and modification time, the result is cached in memory
+// and in capabilities.json in the cache directory, so updating the binary re-detects its options.
+// Returns an error if the binary cannot be found, or its options cannot be detected.
+// Failures are cached in memory per path and modification time as well, and only logged once.
+func detectEngineCapabilities() (*EngineCapabilities, error) {
+	engineCapabilitiesMutex.Lock()
+	defer engineCapabilitiesMutex.Unlock()
+
+	// a missing binary is cached under the configured name, so installing it is noticed on the next call
+	fail := func(binaryPath string, modTime time.Time, err error) (*EngineCapabilities, error) {
+		failed := engineCapabilitiesFailed
+		if failed != nil && failed.binaryPath == binaryPath && failed.modTime.Equal(modTime) {
+			return nil, failed.err
+		}
+		engineCapabilitiesFailed = &engineCapabilitiesFailure{binaryPath: binaryPath, modTime: modTime, err: err}
+		log.Printf("Assuming every option is supported: %v", err)
+		return nil, err
+	}
+
+	binaryPath, err := exec.LookPath(engineBinary())
+	if err != nil {
+		return fail(engineBinary(), time.Time{}, fmt.Errorf("failed to find %s: %v", Config.Constants.LinuxWallpaperEngineBin, err))
+	}
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		return fail(binaryPath, time.Time{}, fmt.Errorf("failed to stat %s: %v", binaryPath, err))
+	}
+
+	if failed := engineCapabilitiesFailed; failed != nil && failed.binaryPath == binaryPath && failed.modTime.Equal(info.ModTime()) {
+		return nil, failed.err
+	}
+
+	isCurrent := func(capabilities *EngineCapabilities) bool {
+		return capabilities != nil && capabilities.BinaryPath == binaryPath && capabilities.ModTime.Equal(info.ModTime())
+	}
+	if isCurrent(engineCapabilities) {
+		return engineCapabilities, nil
+	}
+
+	cacheFile := path.Join(CacheDir, "capabilities.json")
+	if content, err := os.ReadFile(cacheFile); err == nil {
+		cached := &EngineCapabilities{}
+		if err := json.Unmarshal(content, cached); err != nil {
+			log.Printf("Ignoring invalid engine capabilities cache: %v", err)
+		} else if isCurrent(cached) {
+			engineCapabilities = cached
+			return engineCapabilities, nil
+		}
+	}
+
+	log.Printf("Detecting the options supported by %s...", binaryPath)
+	ctx, cancel := context.WithTimeout(context.Background(), EngineHelpTimeout)
+	defer cancel()
+	// some builds exit with a non-zero status after printing the help, so only the output matters
+	output, err := exec.CommandContext(ctx, binaryPath, "--help").CombinedOutput()
+	options := parseHelpOptions(output)
+	if len(options) == 0 {
+		return fail(binaryPath, info.ModTime(), fmt.Errorf("failed to detect the options of %s: %v", binaryPath, err))
+	}
+
+	engineCapabilitiesFailed = nil
+	engineCapabilities = &EngineCapabilities{
+		BinaryPath: binaryPath,
+		ModTime:    info.ModTime(),
+		Options:    options,
+	}
+	log.Printf("%s supports %d options", binaryPath, len(options))
+
+	if content, err := json.MarshalIndent(engineCapabilities, "", "  "); err != nil {
+		log.Printf("Failed to marshal engine capabilities: %v", err)
+	} else if err := os.WriteFile(cacheFile, content, 0644); err != nil {
+		log.Printf("Failed to cache engine capabilities: %v", err)
+	}
+
+	return engineCapabilities, nil
+}
+
+// Returns whether Config.Constants.LinuxWallpaperEngineBin supports the given option, e.g. "--fps".
+//
+// If the supported options cannot be detected, every option is assumed to be supported,
+// so a failed detection never changes the command that is run.
+func engineSupports(option string) bool {
+	capabilities, err := detectEngineCapabilities()
+	if err != nil {
+		return true
+	}
+	return slices.Contains(capabilities.Options, option)
+}
+
+// Same as engineSupports, but also logs when the option is left out of the command.
+func useEngineOption(option string) bool {
+	if engineSupports(option) {
+		return true
+	}
+	log.Printf("Leaving out %s, it is not supported by %s", option, Config.Constants.LinuxWallpaperEngineBin)
+	return false
+}
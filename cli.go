@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Returns the flags that override Config.PostProcessing for a single run, shared by the commands that apply wallpapers.
+//
+// The overrides are not meant to be saved, so those commands only write their changes with updateConfigFile.
+func postProcessingFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolWithInverseFlag{
+			Name:     "post-processing",
+			Usage:    "Override post-processing step, e.g. --post-processing or --no-post-processing. Setting this to false will skip post-processing entirely.",
+			Category: "Post Processing",
+			Required: false,
+			OnlyOnce: true,
+			Action: func(ctx context.Context, c *cli.Command, value bool) error {
+				log.Printf("PostProcessing.Enabled set to %v", value)
+				Config.PostProcessing.Enabled = value
+				return nil
+			},
+		},
+		&cli.DurationFlag{
+			Name:     "artificial-delay",
+			Aliases:  []string{"delay"},
+			Usage:    "Override artificial delay in seconds to wait before post-processing, e.g. --artificial-delay=2s",
+			Category: "Post Processing",
+			Action: func(ctx context.Context, c *cli.Command, value time.Duration) error {
+				log.Printf("PostProcessing.ArtificialDelay set to %vs", int64(value.Seconds()))
+				Config.PostProcessing.ArtificialDelay = int64(value.Seconds())
+				return nil
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:      "screenshot",
+			Usage:     "Override screenshot files to copy output screenshot to, e.g. --screenshot=/path/to/screenshot.png --screenshot=/path/to/another.jpg",
+			TakesFile: true,
+			Category:  "Post Processing",
+			Action: func(ctx context.Context, c *cli.Command, value []string) error {
+				log.Printf("PostProcessing.ScreenshotFiles set to %v", value)
+				Config.PostProcessing.ScreenshotFiles = value
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:     "post-command",
+			Aliases:  []string{"command"},
+			Usage:    "Override post-command to run, e.g. --post-command='your-command'",
+			Category: "Post Processing",
+			Action: func(ctx context.Context, c *cli.Command, value string) error {
+				Config.PostProcessing.PostCommand = value
+				return nil
+			},
+		},
+		&cli.BoolWithInverseFlag{
+			Name:     "swww",
+			Usage:    "Override whether to set the wallpaper using swww after applying the wallpaper, e.g. --swww or --no-swww",
+			Category: "Post Processing",
+			Action: func(ctx context.Context, c *cli.Command, value bool) error {
+				log.Printf("PostProcessing.SetSWWW set to %v", value)
+				Config.PostProcessing.SetSWWW = value
+				return nil
+			},
+		},
+	}
+}
+
+// Returns the post-processing settings with the overrides of postProcessingFlags applied, to send them to the daemon,
+// or nil if none of those flags are set, so the daemon uses its own Config.PostProcessing.
+func postProcessingOverrides(c *cli.Command) *PostProcessingStruct {
+	for _, name := range []string{"post-processing", "artificial-delay", "screenshot", "post-command", "swww"} {
+		if c.IsSet(name) {
+			settings := Config.PostProcessing
+			return &settings
+		}
+	}
+	return nil
+}
+
+// Returns the path of the wallpaper directory for the query of the `apply` command:
+// an absolute path to a wallpaper directory, or a query for findWallpapers.
+//
+// If several wallpapers match, they are returned with an error, so they can be shown to pick from.
+func resolveWallpaperQuery(query string) (string, []WallpaperItem, error) {
+	if filepath.IsAbs(query) {
+		info, err := os.Stat(query)
+		if err != nil || !info.IsDir() {
+			return "", nil, fmt.Errorf("no wallpaper directory found at %s", query)
+		}
+		return query, nil, nil
+	}
+
+	if len(WallpaperItems) == 0 {
+		if err := reloadWallpaperData(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	matches := findWallpapers(query)
+	switch len(matches) {
+	case 0:
+		return "", nil, fmt.Errorf("no wallpaper matches %q", query)
+	case 1:
+		return matches[0].WallpaperPath, matches, nil
+	default:
+		return "", matches, fmt.Errorf("%d wallpapers match %q", len(matches), query)
+	}
+}
+
+// Prints the wallpapers matching an ambiguous query, so the user can pick one by its ID.
+func printWallpaperCandidates(writer io.Writer, query string, candidates []WallpaperItem) error {
+	fmt.Fprintf(writer, "Several wallpapers match %q, use one of the IDs instead:\n\n", query)
+
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tTITLE")
+	for _, item := range candidates {
+		fmt.Fprintf(table, "%s\t%s\n", item.WallpaperID, item.projectJson.Title)
+	}
+	return table.Flush()
+}
+
+// Writes the wallpapers applied by a CLI command to the config file, see updateConfigFile.
+//
+// Only the applied state is written, so the post-processing overrides of the command (see postProcessingFlags)
+// and changes saved by a running GUI are not overwritten.
+func saveAppliedState() error {
+	lastSetIds := Config.SavedUIState.LastSetIds
+	activeProfile := Config.SavedUIState.ActiveProfile
+	profile, hasProfile := Config.Profiles[activeProfile]
+	lastApplied := Config.SavedUIState.LastApplied
+
+	return updateConfigFile(func(config *ConfigStruct) {
+		config.SavedUIState.LastSetIds = lastSetIds
+		if config.SavedUIState.LastApplied == nil {
+			config.SavedUIState.LastApplied = map[string]time.Time{}
+		}
+		for id, appliedAt := range lastApplied {
+			if appliedAt.After(config.SavedUIState.LastApplied[id]) {
+				config.SavedUIState.LastApplied[id] = appliedAt
+			}
+		}
+		config.SavedUIState.ActiveProfile = activeProfile
+		if hasProfile {
+			if config.Profiles == nil {
+				config.Profiles = map[string]ProfileStruct{}
+			}
+			config.Profiles[activeProfile] = profile
+		}
+	})
+}
+
+// The output formats of the `list` command, see printWallpaperList.
+var ListFormats = []string{"table", "json", "tsv", "nul"}
+
+// A wallpaper as printed by the `list` command.
+type WallpaperSummary struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	Type     string    `json:"type"`
+	Tags     []string  `json:"tags"`
+	Path     string    `json:"path"`
+	Favorite bool      `json:"favorite"`
+	Broken   bool      `json:"broken"`
+	Modified time.Time `json:"modified"`
+}
+
+// Returns the summary of the wallpaper printed by the `list` command.
+func summarizeWallpaper(item WallpaperItem) WallpaperSummary {
+	tags := item.projectJson.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return WallpaperSummary{
+		ID:       item.WallpaperID,
+		Title:    item.projectJson.Title,
+		Type:     item.projectJson.Type,
+		Tags:     tags,
+		Path:     item.WallpaperPath,
+		Favorite: item.IsFavorite,
+		Broken:   item.IsBroken,
+		Modified: item.ModTime,
+	}
+}
+
+// Prints the wallpapers in the given format, one of ListFormats:
+//
+//   - table: aligned columns with a header, for reading
+//   - json: an array of WallpaperSummary
+//   - tsv: id, title, type, tags (comma separated), favorite, broken and path, without a header
+//   - nul: only the IDs, each followed by a NUL byte, for xargs -0
+func printWallpaperList(writer io.Writer, items []WallpaperItem, format string) error {
+	switch format {
+	case "json":
+		summaries := []WallpaperSummary{}
+		for _, item := range items {
+			summaries = append(summaries, summarizeWallpaper(item))
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summaries)
+	case "tsv":
+		// tabs and newlines in titles would break the columns
+		clean := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+		for _, item := range items {
+			summary := summarizeWallpaper(item)
+			_, err := fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\t%t\t%s\n", summary.ID, clean.Replace(summary.Title), summary.Type,
+				clean.Replace(strings.Join(summary.Tags, ",")), summary.Favorite, summary.Broken, summary.Path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "nul":
+		for _, item := range items {
+			if _, err := fmt.Fprintf(writer, "%s\x00", item.WallpaperID); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "table":
+		table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tTITLE\tTYPE\tTAGS\tFAVORITE\tBROKEN")
+		for _, item := range items {
+			summary := summarizeWallpaper(item)
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", summary.ID, summary.Title, summary.Type, strings.Join(summary.Tags, ", "), yesNo(summary.Favorite), yesNo(summary.Broken))
+		}
+		return table.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(ListFormats, ", "))
+	}
+}
+
+// A user property of a wallpaper as printed by the `info` command.
+type PropertyInfo struct {
+	Name    string `json:"name"`
+	Label   string `json:"label"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`   // including the override from Config.Wallpapers
+	Default string `json:"default"` // from project.json
+}
+
+// Everything known about a wallpaper, as printed by the `info` command.
+type WallpaperInfo struct {
+	WallpaperSummary
+	Description   string         `json:"description"`
+	PreviewPath   string         `json:"preview_path"`
+	ThumbnailPath string         `json:"thumbnail_path"` // empty if the app has not cached it yet
+	BrokenReason  string         `json:"broken_reason"`
+	LastApplied   *time.Time     `json:"last_applied"` // nil if it was never applied
+	SetOn         []string       `json:"set_on"`       // the outputs it is the last set wallpaper of
+	Properties    []PropertyInfo `json:"properties"`
+	Command       []string       `json:"command"` // for the first target output, see createWallpaperCommand
+}
+
+// Collects everything known about the wallpaper for the `info` command.
+func describeWallpaper(item WallpaperItem) WallpaperInfo {
+	info := WallpaperInfo{
+		WallpaperSummary: summarizeWallpaper(item),
+		Description:      item.projectJson.Description,
+		BrokenReason:     Config.SavedUIState.BrokenReasons[item.WallpaperID],
+		SetOn:            []string{},
+		Properties:       []PropertyInfo{},
+		Command:          []string{},
+	}
+	if item.projectJson.PreviewImage != "" {
+		info.PreviewPath = path.Join(item.WallpaperPath, item.projectJson.PreviewImage)
+	}
+	if _, err := os.Stat(thumbnailCachePath(item.WallpaperID)); err == nil {
+		info.ThumbnailPath = thumbnailCachePath(item.WallpaperID)
+	}
+	if appliedAt, ok := Config.SavedUIState.LastApplied[item.WallpaperID]; ok {
+		info.LastApplied = &appliedAt
+	}
+	for _, output := range slices.Sorted(maps.Keys(Config.SavedUIState.LastSetIds)) {
+		if Config.SavedUIState.LastSetIds[output] == item.WallpaperID {
+			info.SetOn = append(info.SetOn, output)
+		}
+	}
+	for _, property := range item.projectJson.Properties {
+		info.Properties = append(info.Properties, PropertyInfo{
+			Name:    property.Name,
+			Label:   propertyLabel(property),
+			Type:    property.Type,
+			Value:   propertyValue(item.WallpaperID, property),
+			Default: formatPropertyValue(property.Value),
+		})
+	}
+	if outputs := targetOutputs(); len(outputs) > 0 {
+		info.Command, _ = createWallpaperCommand(outputs[0], item.WallpaperPath, float64(Config.SavedUIState.Volume), "", Config.PostProcessing.Enabled)
+	}
+	return info
+}
+
+// Prints the info of the wallpaper, as text or as JSON.
+func printWallpaperInfo(writer io.Writer, info WallpaperInfo, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	lastApplied := "never"
+	if info.LastApplied != nil {
+		lastApplied = info.LastApplied.Local().Format(time.DateTime)
+	}
+	valueOrDash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "ID:\t%s\n", info.ID)
+	fmt.Fprintf(table, "Title:\t%s\n", valueOrDash(info.Title))
+	fmt.Fprintf(table, "Type:\t%s\n", valueOrDash(info.Type))
+	fmt.Fprintf(table, "Tags:\t%s\n", valueOrDash(strings.Join(info.Tags, ", ")))
+	fmt.Fprintf(table, "Path:\t%s\n", info.Path)
+	fmt.Fprintf(table, "Preview:\t%s\n", valueOrDash(info.PreviewPath))
+	fmt.Fprintf(table, "Thumbnail:\t%s\n", valueOrDash(info.ThumbnailPath))
+	fmt.Fprintf(table, "Modified:\t%s\n", info.Modified.Local().Format(time.DateTime))
+	fmt.Fprintf(table, "Favorite:\t%s\n", yesNo(info.Favorite))
+	if info.BrokenReason != "" {
+		fmt.Fprintf(table, "Broken:\t%s (%s)\n", yesNo(info.Broken), info.BrokenReason)
+	} else {
+		fmt.Fprintf(table, "Broken:\t%s\n", yesNo(info.Broken))
+	}
+	fmt.Fprintf(table, "Last applied:\t%s\n", lastApplied)
+	fmt.Fprintf(table, "Set on:\t%s\n", valueOrDash(strings.Join(info.SetOn, ", ")))
+	fmt.Fprintf(table, "Command:\t%s\n", valueOrDash(formatCommand(info.Command)))
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if info.Description != "" {
+		fmt.Fprintf(writer, "\n%s\n", info.Description)
+	}
+
+	if len(info.Properties) > 0 {
+		fmt.Fprintln(writer)
+		table = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "PROPERTY\tLABEL\tTYPE\tVALUE\tDEFAULT")
+		for _, property := range info.Properties {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", property.Name, property.Label, property.Type, property.Value, property.Default)
+		}
+		return table.Flush()
+	}
+	return nil
+}
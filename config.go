@@ -1,41 +1,127 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
 
 type ConstantsStruct struct {
-	DiscardProcessLogs      bool   `toml:"discard_process_logs"      comment:"Whether to pipe detached processes' logs to /dev/null"`
-	LinuxWallpaperEngineBin string `toml:"linux_wallpaperengine_bin" comment:"The absolute path to the binary, in case the binary isn't in PATH"`
-	WallpaperEngineDir      string `toml:"wallpaper_engine_dir"      comment:"The absolute path to the workshop content directory of Wallpaper Engine; where the wallpapers are stored"`
-	WallpaperEngineAssets   string `toml:"wallpaper_engine_assets"   comment:"The absolute path to the assets directory of Wallpaper Engine; https://github.com/Almamu/linux-wallpaperengine#1-get-wallpaper-engine-assets"`
+	DiscardProcessLogs      bool     `toml:"discard_process_logs"      comment:"Whether to pipe detached processes' logs to /dev/null"`
+	LinuxWallpaperEngineBin string   `toml:"linux_wallpaperengine_bin" comment:"The absolute path to the binary, in case the binary isn't in PATH"`
+	WallpaperEngineDir      string   `toml:"wallpaper_engine_dir"      comment:"The absolute path to the workshop content directory of Wallpaper Engine; where the wallpapers are stored"`
+	WallpaperEngineAssets   string   `toml:"wallpaper_engine_assets"   comment:"The absolute path to the assets directory of Wallpaper Engine; https://github.com/Almamu/linux-wallpaperengine#1-get-wallpaper-engine-assets"`
+	Outputs                 []string `toml:"outputs"                   comment:"The outputs (screens) to render wallpapers on, e.g. 'HDMI-A-1', 'eDP-1'; each output can have its own wallpaper"`
+	WatchHotplug            bool     `toml:"watch_hotplug"             comment:"Whether to re-apply the wallpapers when outputs are connected or disconnected while the app is running"`
+	KillTimeout             int64    `toml:"kill_timeout"              comment:"How many seconds to wait for linux-wallpaperengine to exit after SIGTERM, before sending SIGKILL"`
+	EngineLogs              bool     `toml:"engine_logs"               comment:"Whether to write linux-wallpaperengine's output to log files per wallpaper in the cache directory; overrides discard_process_logs for the engine"`
+	EngineLogMaxSize        int64    `toml:"engine_log_max_size"       comment:"The size in KiB an engine log can grow to before it is rotated"`
+	EngineLogKeep           int64    `toml:"engine_log_keep"           comment:"How many engine logs to keep per wallpaper; older ones are removed"`
 }
 
+// Also sent to the daemon as JSON, for the post-processing overrides of the CLI commands, see postProcessingOverrides.
 type PostProcessingStruct struct {
-	Enabled         bool     `toml:"enabled"          comment:"Whether to enable post-processing features below"`
-	ArtificialDelay int64    `toml:"artificial_delay" comment:"Artificial delay in seconds to wait before post-processing; ensures the wallpaper is fully applied"`
-	ScreenshotFiles []string `toml:"screenshot_files" comment:"The files where the output screenshot will be copied to; can be multiple files (Must be PNG, JPG, or BMP)"`
-	PostCommand     string   `toml:"post_command"     comment:"The command to run after the wallpaper is applied, with some placeholders"`
-	SetSWWW         bool     `toml:"set_swww"         comment:"Whether to set the wallpaper using swww after applying the wallpaper; requires screenshot_file to be set and swww to be working"`
+	Enabled         bool     `toml:"enabled"          json:"enabled"          comment:"Whether to enable post-processing features below"`
+	ArtificialDelay int64    `toml:"artificial_delay" json:"artificial_delay" comment:"Artificial delay in seconds to wait before post-processing; ensures the wallpaper is fully applied"`
+	ScreenshotFiles []string `toml:"screenshot_files" json:"screenshot_files" comment:"The files where the output screenshot will be copied to; can be multiple files (Must be PNG, JPG, or BMP)"`
+	PostCommand     string   `toml:"post_command"     json:"post_command"     comment:"The command to run after the wallpaper is applied, with some placeholders"`
+	SetSWWW         bool     `toml:"set_swww"         json:"set_swww"         comment:"Whether to set the wallpaper using swww after applying the wallpaper; requires screenshot_file to be set and swww to be working"`
+}
+
+type EngineStruct struct {
+	FPS               int64 `toml:"fps"                 comment:"The frame rate limit of the wallpapers, lower saves battery; 0 = let linux-wallpaperengine decide (--fps)"`
+	NoFullscreenPause bool  `toml:"no_fullscreen_pause" comment:"Whether to keep rendering the wallpapers while a fullscreen window is open (--no-fullscreen-pause)"`
+	DisableMouse      bool  `toml:"disable_mouse"       comment:"Whether to disable mouse interaction with the wallpapers (--disable-mouse)"`
+	NoAutomute        bool  `toml:"no_automute"         comment:"Whether to keep the wallpapers' audio playing while other applications play audio (--noautomute)"`
+	NoAudioProcessing bool  `toml:"no_audio_processing" comment:"Whether to disable audio processing for audio-reactive wallpapers (--no-audio-processing)"`
+}
+
+type SupervisorStruct struct {
+	Enabled         bool   `toml:"enabled"           comment:"Whether to restart linux-wallpaperengine when it crashes, waiting longer after every crash"`
+	MaxCrashes      int64  `toml:"max_crashes"       comment:"After this many crashes within crash_window, the wallpaper is marked as broken and no longer restarted"`
+	CrashWindow     int64  `toml:"crash_window"      comment:"The time window in seconds that crashes are counted in"`
+	SafeWallpaperId string `toml:"safe_wallpaper_id" comment:"The wallpaper ID to apply instead of a wallpaper that keeps crashing; empty = leave the output without a wallpaper"`
+}
+
+type BrokenDetectionStruct struct {
+	Enabled  bool     `toml:"enabled"  comment:"Whether to automatically mark wallpapers as broken when linux-wallpaperengine fails to run them"`
+	Window   int64    `toml:"window"   comment:"How many seconds after applying a wallpaper to watch linux-wallpaperengine for failures"`
+	Patterns []string `toml:"patterns" comment:"Regular expressions matched against the engine log; a match marks the wallpaper as broken"`
+}
+
+type PowerPolicyStruct struct {
+	Enabled             bool   `toml:"enabled"               comment:"Whether to apply on_battery while running on battery; the previous state is restored on AC"`
+	SysfsRoot           string `toml:"sysfs_root"            comment:"The directory to read the power supplies from"`
+	Interval            int64  `toml:"interval"              comment:"How often to check the power supplies, in seconds"`
+	OnBattery           string `toml:"on_battery"            comment:"What to do on battery. 'none', 'lower_fps', 'mute', 'fallback', 'pause'"`
+	BatteryFPS          int64  `toml:"battery_fps"           comment:"The frame rate limit on battery, used by 'lower_fps'"`
+	FallbackWallpaperId string `toml:"fallback_wallpaper_id" comment:"The lightweight wallpaper ID to show on battery, used by 'fallback'"`
+}
+
+type GameModeStruct struct {
+	Enabled   bool     `toml:"enabled"   comment:"Whether to pause or kill the wallpapers while one of the processes below is running"`
+	Processes []string `toml:"processes" comment:"Process names to watch for, e.g. 'blender', 'obs'"`
+	Patterns  []string `toml:"patterns"  comment:"Regular expressions matched against the full command line of processes, e.g. 'steamapps/common/'"`
+	Action    string   `toml:"action"    comment:"What to do while a process is running. 'pause' = resume afterwards, 'kill' = restore afterwards"`
+	Interval  int64    `toml:"interval"  comment:"How often to check the running processes, in seconds"`
 }
 
 type SavedUIStateStruct struct {
-	LastSetId  string   `toml:"last_set_id" comment:"The last set wallpaper ID, used for restoring the wallpaper"` // # TODO: add multi monitor support
-	SortBy     string   `toml:"sort_by"     comment:"The criteria to sort wallpapers by. 'date_desc', 'date_asc', 'name_desc', 'name_asc'"`
-	Volume     int64    `toml:"volume"      comment:"The volume level for the wallpaper engine, 0-100; 0 = --silent, > 0 = --volume <value>"`
-	HideBroken bool     `toml:"hide_broken" comment:"Whether to hide broken wallpapers from the UI"`
-	Broken     []string `toml:"broken"      comment:"Wallpapers marked as 'broken'; can be hidden from UI or shown at the end of the list"`
-	Favorites  []string `toml:"favorites"   comment:"Wallpapers marked as 'favorite'; shown at the top of the list"`
+	LastSetId     string               `toml:"last_set_id,omitempty" comment:"Deprecated, use last_set_ids instead; migrated to every configured output when loaded"`
+	LastSetIds    map[string]string    `toml:"last_set_ids"          comment:"The last set wallpaper ID per output, used for restoring the wallpapers"`
+	LastApplied   map[string]time.Time `toml:"last_applied"          comment:"When each wallpaper was last applied, keyed by wallpaper ID"`
+	TargetOutput  string               `toml:"target_output"         comment:"The output wallpapers are applied to from the UI; empty = all configured outputs"`
+	ActiveProfile string               `toml:"active_profile"        comment:"The profile that was last applied; empty if the wallpapers were not applied from a profile"`
+	SortBy        string               `toml:"sort_by"               comment:"The criteria to sort wallpapers by. 'date_desc', 'date_asc', 'name_desc', 'name_asc', 'cpu_desc', 'cpu_asc'"`
+	Volume        int64                `toml:"volume"                comment:"The volume level for the wallpaper engine, 0-100; 0 = --silent, > 0 = --volume <value>"`
+	HideBroken    bool                 `toml:"hide_broken"           comment:"Whether to hide broken wallpapers from the UI"`
+	MaxAverageCPU int64                `toml:"max_average_cpu"       comment:"Hide wallpapers that averaged more than this CPU usage in percent while running; 0 = show all"`
+	Broken        []string             `toml:"broken"                comment:"Wallpapers marked as 'broken'; can be hidden from UI or shown at the end of the list"`
+	BrokenReasons map[string]string    `toml:"broken_reasons"        comment:"Why wallpapers were automatically marked as 'broken', keyed by wallpaper ID"`
+	Favorites     []string             `toml:"favorites"             comment:"Wallpapers marked as 'favorite'; shown at the top of the list"`
+	Scaling       string               `toml:"scaling"               comment:"The default scaling mode for wallpapers. 'default', 'stretch', 'fit', 'fill'; 'default' = let linux-wallpaperengine decide"`
+	Clamping      string               `toml:"clamping"              comment:"The default clamping mode for wallpapers. 'default', 'clamp', 'border', 'repeat'; 'default' = let linux-wallpaperengine decide"`
+}
+
+type ProfileOutputStruct struct {
+	WallpaperId string `toml:"wallpaper_id" comment:"The wallpaper ID to set on this output"`
+	Volume      int64  `toml:"volume"       comment:"The volume level for this output, 0-100; 0 = --silent, > 0 = --volume <value>"`
+	Scaling     string `toml:"scaling"      comment:"The scaling mode for this output. 'default', 'stretch', 'fit', 'fill'; empty = use the wallpaper's scaling mode"`
+}
+
+type ProfileStruct struct {
+	Outputs map[string]ProfileOutputStruct `toml:"outputs" comment:"The settings per output; the profile is applied automatically when exactly these outputs are connected"`
+}
+
+type WallpaperSettingsStruct struct {
+	Scaling           string            `toml:"scaling,omitempty"             comment:"The scaling mode for this wallpaper; empty = use the default scaling mode"`
+	Clamping          string            `toml:"clamping,omitempty"            comment:"The clamping mode for this wallpaper; empty = use the default clamping mode"`
+	FPS               *int64            `toml:"fps,omitempty"                 comment:"Overrides Engine.fps for this wallpaper"`
+	NoFullscreenPause *bool             `toml:"no_fullscreen_pause,omitempty" comment:"Overrides Engine.no_fullscreen_pause for this wallpaper"`
+	DisableMouse      *bool             `toml:"disable_mouse,omitempty"       comment:"Overrides Engine.disable_mouse for this wallpaper"`
+	NoAutomute        *bool             `toml:"no_automute,omitempty"         comment:"Overrides Engine.no_automute for this wallpaper"`
+	NoAudioProcessing *bool             `toml:"no_audio_processing,omitempty" comment:"Overrides Engine.no_audio_processing for this wallpaper"`
+	Properties        map[string]string `toml:"properties,omitempty"          comment:"Overrides of the wallpaper's user properties from its project.json, passed as --set-property name=value"`
 }
 
 type ConfigStruct struct {
-	Constants      ConstantsStruct      `toml:"Constants"`
-	PostProcessing PostProcessingStruct `toml:"PostProcessing"`
-	SavedUIState   SavedUIStateStruct   `toml:"SavedUIState"`
+	Constants       ConstantsStruct                    `toml:"Constants"`
+	PostProcessing  PostProcessingStruct               `toml:"PostProcessing"`
+	Engine          EngineStruct                       `toml:"Engine"`
+	Supervisor      SupervisorStruct                   `toml:"Supervisor"`
+	BrokenDetection BrokenDetectionStruct              `toml:"BrokenDetection"`
+	PowerPolicy     PowerPolicyStruct                  `toml:"PowerPolicy"`
+	GameMode        GameModeStruct                     `toml:"GameMode"`
+	SavedUIState    SavedUIStateStruct                 `toml:"SavedUIState"`
+	Profiles        map[string]ProfileStruct           `toml:"Profiles"   comment:"Named display layouts, e.g. 'docked' or 'laptop-only', keyed by their name"`
+	Wallpapers      map[string]WallpaperSettingsStruct `toml:"Wallpapers" comment:"Per-wallpaper settings, keyed by wallpaper ID"`
 }
 
 // Creates a new default ConfigStruct with sensible defaults
@@ -54,6 +140,12 @@ func NewDefaultConfig(configDir string) *ConfigStruct {
 			LinuxWallpaperEngineBin: "linux-wallpaperengine",
 			WallpaperEngineDir:      path.Join(os.Getenv("HOME"), ".steam", "steam", "steamapps", "workshop", "content", "431960"),
 			WallpaperEngineAssets:   "",
+			Outputs:                 []string{"HDMI-A-1"},
+			WatchHotplug:            true,
+			KillTimeout:             5,
+			EngineLogs:              true,
+			EngineLogMaxSize:        1024,
+			EngineLogKeep:           5,
 		},
 		PostProcessing: PostProcessingStruct{
 			Enabled:         false,
@@ -62,14 +154,61 @@ func NewDefaultConfig(configDir string) *ConfigStruct {
 			PostCommand:     "",
 			SetSWWW:         false,
 		},
+		Engine: EngineStruct{
+			FPS:               0,
+			NoFullscreenPause: false,
+			DisableMouse:      false,
+			NoAutomute:        false,
+			NoAudioProcessing: false,
+		},
+		Supervisor: SupervisorStruct{
+			Enabled:         true,
+			MaxCrashes:      3,
+			CrashWindow:     60,
+			SafeWallpaperId: "",
+		},
+		BrokenDetection: BrokenDetectionStruct{
+			Enabled: true,
+			Window:  10,
+			Patterns: []string{
+				`(?i)(failed to|cannot|could not) (find|load|compile) shader`,
+				`(?i)shader compilation failed`,
+				`(?i)unsupported (wallpaper|background|project) type`,
+				`(?i)cannot find .* in (assets|background)`,
+				`(?i)^(error:\s*)?(asset|file|texture|model) not found: \S+`,
+			},
+		},
+		PowerPolicy: PowerPolicyStruct{
+			Enabled:             false,
+			SysfsRoot:           "/sys/class/power_supply",
+			Interval:            30,
+			OnBattery:           "lower_fps",
+			BatteryFPS:          15,
+			FallbackWallpaperId: "",
+		},
+		GameMode: GameModeStruct{
+			Enabled:   false,
+			Processes: []string{},
+			Patterns:  []string{`steamapps/common/`},
+			Action:    "pause",
+			Interval:  5,
+		},
 		SavedUIState: SavedUIStateStruct{
-			LastSetId:  "",
-			SortBy:     "date_desc",
-			Volume:     100,
-			HideBroken: false,
-			Broken:     []string{},
-			Favorites:  []string{},
+			LastSetIds:    map[string]string{},
+			LastApplied:   map[string]time.Time{},
+			TargetOutput:  "",
+			SortBy:        "date_desc",
+			Volume:        100,
+			HideBroken:    false,
+			MaxAverageCPU: 0,
+			Broken:        []string{},
+			BrokenReasons: map[string]string{},
+			Favorites:     []string{},
+			Scaling:       "default",
+			Clamping:      "default",
 		},
+		Profiles:   map[string]ProfileStruct{},
+		Wallpapers: map[string]WallpaperSettingsStruct{},
 	}
 }
 
@@ -111,6 +250,46 @@ func readOrCreateConfig(configFile string, config *ConfigStruct) error {
 	return nil
 }
 
+// Re-reads the config file into a new Config, e.g. after another process saved it.
+//
+// Unlike readOrCreateConfig, it does not exit on errors; the current Config is kept instead.
+func reloadConfig() error {
+	configDir, err := ensureConfigDir()
+	if err != nil {
+		return fmt.Errorf("failed to ensure config directory: %v", err)
+	}
+
+	content, err := os.ReadFile(path.Join(configDir, "config.toml"))
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	config := NewDefaultConfig(configDir)
+	if err := toml.Unmarshal(content, config); err != nil {
+		return fmt.Errorf("failed to unmarshal config file: %v", err)
+	}
+
+	Config = config
+	validateConfig()
+	log.Printf("Config file reloaded from: %s", path.Join(configDir, "config.toml"))
+	return nil
+}
+
+// Returns a deep copy of the config, so it can be read while Config changes, e.g. by an apply request, see queueApply.
+// Falls back to a shallow copy if the config cannot be copied through TOML, which should never happen.
+func cloneConfig(config *ConfigStruct) *ConfigStruct {
+	clone := &ConfigStruct{}
+	content, err := toml.Marshal(config)
+	if err == nil {
+		err = toml.Unmarshal(content, clone)
+	}
+	if err != nil {
+		log.Printf("Failed to copy the config, using a shallow copy: %v", err)
+		shallow := *config
+		return &shallow
+	}
+	return clone
+}
+
 // Makes sure required fields are set in the Config.
 // If some fields are invalid, it will correct them by setting them to the default.
 func validateConfig() {
@@ -125,6 +304,108 @@ func validateConfig() {
 	if Config.Constants.WallpaperEngineAssets == "" {
 		Config.Constants.WallpaperEngineAssets = defaultConfig.Constants.WallpaperEngineAssets
 	}
+
+	Config.Constants.Outputs = slices.DeleteFunc(Config.Constants.Outputs, func(output string) bool {
+		return strings.TrimSpace(output) == ""
+	})
+	if len(Config.Constants.Outputs) == 0 {
+		Config.Constants.Outputs = defaultConfig.Constants.Outputs
+	}
+
+	if Config.Constants.KillTimeout <= 0 {
+		Config.Constants.KillTimeout = defaultConfig.Constants.KillTimeout
+	}
+
+	if Config.Constants.EngineLogMaxSize <= 0 {
+		Config.Constants.EngineLogMaxSize = defaultConfig.Constants.EngineLogMaxSize
+	}
+	if Config.Constants.EngineLogKeep <= 0 {
+		Config.Constants.EngineLogKeep = defaultConfig.Constants.EngineLogKeep
+	}
+
+	if Config.Engine.FPS < 0 {
+		Config.Engine.FPS = defaultConfig.Engine.FPS
+	}
+
+	if Config.Supervisor.MaxCrashes <= 0 {
+		Config.Supervisor.MaxCrashes = defaultConfig.Supervisor.MaxCrashes
+	}
+	if Config.Supervisor.CrashWindow <= 0 {
+		Config.Supervisor.CrashWindow = defaultConfig.Supervisor.CrashWindow
+	}
+
+	if !slices.Contains(SortModes, Config.SavedUIState.SortBy) {
+		Config.SavedUIState.SortBy = defaultConfig.SavedUIState.SortBy
+	}
+	if Config.SavedUIState.MaxAverageCPU < 0 {
+		Config.SavedUIState.MaxAverageCPU = defaultConfig.SavedUIState.MaxAverageCPU
+	}
+	if !slices.Contains(ScalingModes, Config.SavedUIState.Scaling) {
+		Config.SavedUIState.Scaling = defaultConfig.SavedUIState.Scaling
+	}
+	if !slices.Contains(ClampingModes, Config.SavedUIState.Clamping) {
+		Config.SavedUIState.Clamping = defaultConfig.SavedUIState.Clamping
+	}
+	if Config.Wallpapers == nil {
+		Config.Wallpapers = map[string]WallpaperSettingsStruct{}
+	}
+
+	if Config.Profiles == nil {
+		Config.Profiles = map[string]ProfileStruct{}
+	}
+	for name, profile := range Config.Profiles {
+		if profile.Outputs == nil {
+			profile.Outputs = map[string]ProfileOutputStruct{}
+			Config.Profiles[name] = profile
+		}
+	}
+
+	if Config.BrokenDetection.Window <= 0 {
+		Config.BrokenDetection.Window = defaultConfig.BrokenDetection.Window
+	}
+	if Config.PowerPolicy.SysfsRoot == "" {
+		Config.PowerPolicy.SysfsRoot = defaultConfig.PowerPolicy.SysfsRoot
+	}
+	if Config.PowerPolicy.Interval <= 0 {
+		Config.PowerPolicy.Interval = defaultConfig.PowerPolicy.Interval
+	}
+	if !slices.Contains(PowerPolicyModes, Config.PowerPolicy.OnBattery) {
+		Config.PowerPolicy.OnBattery = defaultConfig.PowerPolicy.OnBattery
+	}
+	if Config.PowerPolicy.BatteryFPS <= 0 {
+		Config.PowerPolicy.BatteryFPS = defaultConfig.PowerPolicy.BatteryFPS
+	}
+
+	isBlank := func(value string) bool { return strings.TrimSpace(value) == "" }
+	Config.GameMode.Processes = slices.DeleteFunc(Config.GameMode.Processes, isBlank)
+	Config.GameMode.Patterns = slices.DeleteFunc(Config.GameMode.Patterns, isBlank)
+	if !slices.Contains(GameModeActions, Config.GameMode.Action) {
+		Config.GameMode.Action = defaultConfig.GameMode.Action
+	}
+	if Config.GameMode.Interval <= 0 {
+		Config.GameMode.Interval = defaultConfig.GameMode.Interval
+	}
+
+	if Config.SavedUIState.BrokenReasons == nil {
+		Config.SavedUIState.BrokenReasons = map[string]string{}
+	}
+	if Config.SavedUIState.LastApplied == nil {
+		Config.SavedUIState.LastApplied = map[string]time.Time{}
+	}
+
+	if Config.SavedUIState.LastSetIds == nil {
+		Config.SavedUIState.LastSetIds = map[string]string{}
+	}
+	// configs from before multi monitor support only have a single last_set_id
+	// so we set it on every configured output, as that is what it used to be applied to
+	if Config.SavedUIState.LastSetId != "" {
+		if len(Config.SavedUIState.LastSetIds) == 0 {
+			for _, output := range Config.Constants.Outputs {
+				Config.SavedUIState.LastSetIds[output] = Config.SavedUIState.LastSetId
+			}
+		}
+		Config.SavedUIState.LastSetId = ""
+	}
 }
 
 // Saves the Config to config.toml in the config directory.
@@ -139,13 +420,18 @@ func saveConfig() error {
 	validateConfig()
 
 	configFile := path.Join(configDir, "config.toml")
-	content, err := toml.Marshal(Config)
-	if err != nil {
-		log.Printf("Failed to marshal config to TOML: %v", err)
-		return err
-	}
+	err = withConfigLock(func(configFile string) error {
+		// so the favorites and broken marks changed by another process are not overwritten
+		if err := mergeConfigFileMarks(configFile); err != nil {
+			log.Printf("Failed to read the marks from the config file, overwriting them: %v", err)
+		}
 
-	err = os.WriteFile(configFile, content, 0644)
+		content, err := toml.Marshal(Config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal config to TOML: %v", err)
+		}
+		return os.WriteFile(configFile, content, 0644)
+	})
 	if err != nil {
 		log.Printf("Failed to write config file: %v", err)
 		return err
@@ -154,3 +440,58 @@ func saveConfig() error {
 	log.Printf("Config saved to: %s", configFile)
 	return nil
 }
+
+// Runs fn while holding an exclusive lock on the config file (config.toml.lock),
+// so processes writing the config at the same time do not overwrite each other's changes halfway.
+func withConfigLock(fn func(configFile string) error) error {
+	configDir, err := ensureConfigDir()
+	if err != nil {
+		return fmt.Errorf("failed to ensure config directory: %v", err)
+	}
+	configFile := path.Join(configDir, "config.toml")
+
+	lockFile, err := os.OpenFile(configFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the config lock: %v", err)
+	}
+	defer lockFile.Close()
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock the config file: %v", err)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	return fn(configFile)
+}
+
+// Re-reads the config file, applies the update to it, and writes it back, while holding the config lock.
+// The update is applied to Config as well.
+//
+// Unlike saveConfig, which writes all of Config, only what the update changes is written,
+// so changes saved by another process in the meantime are kept.
+func updateConfigFile(update func(config *ConfigStruct)) error {
+	return withConfigLock(func(configFile string) error {
+		config := NewDefaultConfig(path.Dir(configFile))
+		content, err := os.ReadFile(configFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read config file: %v", err)
+		} else if err == nil {
+			if err := toml.Unmarshal(content, config); err != nil {
+				return fmt.Errorf("failed to unmarshal config file: %v", err)
+			}
+		}
+
+		update(config)
+		update(Config)
+
+		content, err = toml.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal config to TOML: %v", err)
+		}
+		if err := os.WriteFile(configFile, content, 0644); err != nil {
+			return fmt.Errorf("failed to write config file: %v", err)
+		}
+		log.Printf("Config updated in: %s", configFile)
+		return nil
+	})
+}
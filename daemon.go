@@ -0,0 +1,654 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/core/glib"
+)
+
+// How long a client waits for the daemon to answer a request; applying includes killing the old engines and post-processing.
+var DaemonRequestTimeout = 60 * time.Second
+
+// Whether the GUI forwards its requests to a running daemon instead of applying wallpapers itself, see activate.
+var UseDaemon bool = false
+
+// A request of the daemon's control protocol: one JSON object per line, answered by a DaemonResponse.
+type DaemonRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type DaemonResponse struct {
+	Ok     bool          `json:"ok"`
+	Error  string        `json:"error,omitempty"`
+	Status *DaemonStatus `json:"status,omitempty"`
+}
+
+// The params of the "apply" method. Wallpaper is a wallpaper ID or the path to a wallpaper directory.
+// Without Outputs, the wallpaper is applied to the target outputs, and without Volume, with Config.SavedUIState.Volume.
+// PostProcessing replaces Config.PostProcessing for this request only, see withPostProcessing.
+type ApplyParams struct {
+	Wallpaper      string                `json:"wallpaper"`
+	Outputs        []string              `json:"outputs,omitempty"`
+	Volume         *int64                `json:"volume,omitempty"`
+	PostProcessing *PostProcessingStruct `json:"post_processing,omitempty"`
+}
+
+// The params of the "restore" method. PostProcessing replaces Config.PostProcessing for this request only.
+type RestoreParams struct {
+	PostProcessing *PostProcessingStruct `json:"post_processing,omitempty"`
+}
+
+// The params of the "next" and "previous" methods. Without Outputs, the target outputs are used.
+type TargetParams struct {
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+// The params of the "random" method. Without Outputs, the target outputs are used.
+// The other fields filter the wallpapers to pick from, see WallpaperFilter; ExcludeRecent skips the most recently applied ones.
+// PostProcessing replaces Config.PostProcessing for this request only.
+type RandomParams struct {
+	Outputs        []string              `json:"outputs,omitempty"`
+	FavoritesOnly  bool                  `json:"favorites_only,omitempty"`
+	Tags           []string              `json:"tags,omitempty"`
+	Type           string                `json:"type,omitempty"`
+	Search         string                `json:"search,omitempty"`
+	ExcludeRecent  int                   `json:"exclude_recent,omitempty"`
+	PostProcessing *PostProcessingStruct `json:"post_processing,omitempty"`
+}
+
+// The params of the "pause" method. Paused pauses or resumes the wallpapers, and toggles them if it is not set.
+type PauseParams struct {
+	Paused *bool `json:"paused,omitempty"`
+}
+
+// The state returned by every method.
+type DaemonStatus struct {
+	PID           int               `json:"pid"`    // of the process that handled the request
+	Daemon        bool              `json:"daemon"` // whether the request was handled by a daemon
+	Paused        bool              `json:"paused"`
+	LastSetIds    map[string]string `json:"last_set_ids"`
+	ActiveProfile string            `json:"active_profile"`
+	PowerPolicy   string            `json:"power_policy"`
+	GameMode      bool              `json:"game_mode"`
+	Engines       []TrackedProcess  `json:"engines"`
+}
+
+// A method of the control protocol. Methods that change the state save the config afterwards.
+type controlMethod struct {
+	handle      func(params json.RawMessage) error
+	savesConfig bool
+}
+
+var controlMethods = map[string]controlMethod{
+	"apply":    {handle: controlApply, savesConfig: true},
+	"random":   {handle: controlRandom, savesConfig: true},
+	"next":     {handle: func(params json.RawMessage) error { return controlStep(params, 1) }, savesConfig: true},
+	"previous": {handle: func(params json.RawMessage) error { return controlStep(params, -1) }, savesConfig: true},
+	"restore":  {handle: controlRestore, savesConfig: true},
+	"pause":    {handle: controlPause},
+	"status":   {handle: func(params json.RawMessage) error { return nil }},
+	"reload":   {handle: controlReload},
+}
+
+// Guards WallpaperItems and Config while a control method reads or reloads them.
+var controlMutex sync.Mutex
+
+// Runs the update of WallpaperItems or Config from a goroutine, e.g. the supervisor: on the GTK main thread if the GUI is running,
+// as the GUI only changes them there, or holding controlMutex otherwise, like the control methods.
+//
+// With the GUI, the update runs asynchronously.
+func runStateUpdate(update func()) {
+	if StatusText != nil {
+		glib.IdleAdd(update)
+		return
+	}
+
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+	update()
+}
+
+// Whether this process is the daemon, see runDaemon.
+var isDaemon bool = false
+
+// Returns the path of the daemon's control socket, $XDG_RUNTIME_DIR/linux-wallpaperengine-helper.sock,
+// or daemon.sock in the fallbackRuntimeDir without $XDG_RUNTIME_DIR.
+func daemonSocketPath() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return filepath.Join(fallbackRuntimeDir(), "daemon.sock")
+	}
+	return filepath.Join(runtimeDir, "linux-wallpaperengine-helper.sock")
+}
+
+// Connects to the daemon's control socket. Without $XDG_RUNTIME_DIR, the socket is only trusted
+// if its directory belongs to the current user, see checkPrivateDir.
+func dialDaemon() (net.Conn, error) {
+	if os.Getenv("XDG_RUNTIME_DIR") == "" {
+		if err := checkPrivateDir(fallbackRuntimeDir()); err != nil {
+			return nil, err
+		}
+	}
+	return net.DialTimeout("unix", daemonSocketPath(), time.Second)
+}
+
+// Returns whether a daemon is listening on the control socket.
+func daemonRunning() bool {
+	conn, err := dialDaemon()
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Sends a request to the running daemon and returns the status it answered with.
+func callDaemon(method string, params any) (DaemonStatus, error) {
+	request := DaemonRequest{Method: method}
+	if params != nil {
+		encoded, err := json.Marshal(params)
+		if err != nil {
+			return DaemonStatus{}, fmt.Errorf("failed to marshal params: %v", err)
+		}
+		request.Params = encoded
+	}
+
+	conn, err := dialDaemon()
+	if err != nil {
+		return DaemonStatus{}, fmt.Errorf("failed to connect to the daemon: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(DaemonRequestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return DaemonStatus{}, fmt.Errorf("failed to send request to the daemon: %v", err)
+	}
+	response := DaemonResponse{}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return DaemonStatus{}, fmt.Errorf("failed to read response from the daemon: %v", err)
+	}
+
+	status := DaemonStatus{}
+	if response.Status != nil {
+		status = *response.Status
+	}
+	if !response.Ok {
+		return status, errors.New(response.Error)
+	}
+	return status, nil
+}
+
+// Runs a control method on the running daemon, or in this process if no daemon is running.
+//
+// Returns the status after the method ran, and an error if it failed.
+func callControlMethod(method string, params any) (DaemonStatus, error) {
+	if daemonRunning() {
+		return callDaemon(method, params)
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return DaemonStatus{}, fmt.Errorf("failed to marshal params: %v", err)
+	}
+	response := handleControlRequest(DaemonRequest{Method: method, Params: encoded})
+	if !response.Ok {
+		return *response.Status, errors.New(response.Error)
+	}
+	return *response.Status, nil
+}
+
+// Runs the method of the request and returns the response with the resulting status.
+func handleControlRequest(request DaemonRequest) DaemonResponse {
+	method, ok := controlMethods[request.Method]
+	if !ok {
+		return DaemonResponse{Error: fmt.Sprintf("unknown method: %s", request.Method), Status: currentStatus()}
+	}
+
+	err := method.handle(request.Params)
+	if err == nil && method.savesConfig {
+		controlMutex.Lock()
+		saveConfig()
+		controlMutex.Unlock()
+	}
+
+	response := DaemonResponse{Ok: err == nil, Status: currentStatus()}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	return response
+}
+
+// Unmarshals the params of a request into the given struct. Empty params leave it unchanged.
+func decodeParams(params json.RawMessage, target any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(params, target); err != nil {
+		return fmt.Errorf("invalid params: %v", err)
+	}
+	return nil
+}
+
+// Returns the given outputs, or the target outputs if none are given, see targetOutputs.
+func outputsOrTarget(outputs []string) []string {
+	if len(outputs) == 0 {
+		return targetOutputs()
+	}
+	return outputs
+}
+
+// Returns the path of the wallpaper directory for the given wallpaper ID or path.
+func resolveWallpaperArgument(wallpaper string) (string, error) {
+	if wallpaper == "" {
+		return "", fmt.Errorf("no wallpaper given")
+	}
+
+	wallpaperPath := wallpaper
+	if !strings.Contains(wallpaper, "/") {
+		wallpaperPath = path.Join(Config.Constants.WallpaperEngineDir, wallpaper)
+	}
+	wallpaperPath, err := resolvePath(wallpaperPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve wallpaper path: %v", err)
+	}
+
+	info, err := os.Stat(wallpaperPath)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("no wallpaper found at %s", wallpaperPath)
+	}
+	return wallpaperPath, nil
+}
+
+// Handles the "apply" method, see ApplyParams.
+func controlApply(params json.RawMessage) error {
+	applyParams := ApplyParams{}
+	if err := decodeParams(params, &applyParams); err != nil {
+		return err
+	}
+
+	controlMutex.Lock()
+	wallpaperPath, err := resolveWallpaperArgument(applyParams.Wallpaper)
+	volume := Config.SavedUIState.Volume
+	if applyParams.Volume != nil {
+		volume = *applyParams.Volume
+	}
+	outputs := outputsOrTarget(applyParams.Outputs)
+	controlMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return <-queueApply("wallpaper "+path.Base(wallpaperPath), func(ctx context.Context) error {
+		return applyWallpaper(withPostProcessing(ctx, applyParams.PostProcessing), wallpaperPath, float64(volume), outputs...)
+	})
+}
+
+// Handles the "random" method, see RandomParams.
+func controlRandom(params json.RawMessage) error {
+	randomParams := RandomParams{}
+	if err := decodeParams(params, &randomParams); err != nil {
+		return err
+	}
+	return applyFilteredRandomWallpaper(randomParams)
+}
+
+// Applies a random wallpaper of the ones matching the filters of the params, see RandomParams.
+func applyFilteredRandomWallpaper(params RandomParams) error {
+	controlMutex.Lock()
+	if len(WallpaperItems) == 0 {
+		if err := reloadWallpaperData(); err != nil {
+			controlMutex.Unlock()
+			return err
+		}
+	}
+	candidates := filterWallpaperItems(WallpaperItems, WallpaperFilter{
+		FavoritesOnly: params.FavoritesOnly,
+		Tags:          params.Tags,
+		Type:          params.Type,
+		Search:        params.Search,
+		ExcludeIds:    recentlyAppliedIds(params.ExcludeRecent),
+	})
+	if len(candidates) == 0 {
+		controlMutex.Unlock()
+		return fmt.Errorf("no wallpapers match the filters")
+	}
+	wallpaper, err := pickRandomWallpaper(candidates)
+	outputs := outputsOrTarget(params.Outputs)
+	controlMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Applying random wallpaper: %s", wallpaper.WallpaperID)
+	return <-queueApply("random wallpaper", func(ctx context.Context) error {
+		return applyWallpaper(withPostProcessing(ctx, params.PostProcessing), wallpaper.WallpaperPath, float64(applyConfig(ctx).SavedUIState.Volume), outputs...)
+	})
+}
+
+// Handles the "next" (step 1) and "previous" (step -1) methods, see TargetParams.
+//
+// Applies the wallpaper after or before the one on the first output, in the order of the wallpaper list, skipping broken ones.
+func controlStep(params json.RawMessage, step int) error {
+	targetParams := TargetParams{}
+	if err := decodeParams(params, &targetParams); err != nil {
+		return err
+	}
+
+	controlMutex.Lock()
+	if len(WallpaperItems) == 0 {
+		if err := reloadWallpaperData(); err != nil {
+			controlMutex.Unlock()
+			return err
+		}
+	}
+	sortWallpaperItems()
+	candidates := slices.DeleteFunc(slices.Clone(WallpaperItems), func(item WallpaperItem) bool { return item.IsBroken })
+	outputs := outputsOrTarget(targetParams.Outputs)
+	currentId := ""
+	if len(outputs) > 0 {
+		currentId = Config.SavedUIState.LastSetIds[outputs[0]]
+	}
+	controlMutex.Unlock()
+	if len(candidates) == 0 {
+		return fmt.Errorf("no non-broken wallpapers available to apply")
+	}
+
+	index := slices.IndexFunc(candidates, func(item WallpaperItem) bool { return item.WallpaperID == currentId })
+	if index == -1 && step < 0 {
+		// so "previous" starts at the last wallpaper, like "next" starts at the first one
+		index = len(candidates)
+	}
+	wallpaper := candidates[((index+step)%len(candidates)+len(candidates))%len(candidates)]
+
+	log.Printf("Applying wallpaper %s", wallpaper.WallpaperID)
+	return <-queueApply("wallpaper "+wallpaper.WallpaperID, func(ctx context.Context) error {
+		return applyWallpaper(ctx, wallpaper.WallpaperPath, float64(applyConfig(ctx).SavedUIState.Volume), outputs...)
+	})
+}
+
+// Handles the "restore" method, see restoreWallpaper and RestoreParams.
+func controlRestore(params json.RawMessage) error {
+	restoreParams := RestoreParams{}
+	if err := decodeParams(params, &restoreParams); err != nil {
+		return err
+	}
+	return <-queueApply("restore", func(ctx context.Context) error {
+		return restoreWallpaper(withPostProcessing(ctx, restoreParams.PostProcessing))
+	})
+}
+
+// Handles the "pause" method, see PauseParams.
+func controlPause(params json.RawMessage) error {
+	pauseParams := PauseParams{}
+	if err := decodeParams(params, &pauseParams); err != nil {
+		return err
+	}
+
+	if pauseParams.Paused == nil {
+		_, err := togglePauseEngines()
+		return err
+	}
+	return setEnginesPaused(*pauseParams.Paused)
+}
+
+// Handles the "reload" method, which re-reads the config file and the wallpapers, e.g. after the GUI saved its changes.
+func controlReload(params json.RawMessage) error {
+	// the running apply reads Config, so wait for it before replacing it
+	applyRunningMutex.Lock()
+	defer applyRunningMutex.Unlock()
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	if err := reloadConfig(); err != nil {
+		return err
+	}
+	return reloadWallpaperData()
+}
+
+// Returns the current state of the wallpapers.
+func currentStatus() *DaemonStatus {
+	engines, err := runningTrackedProcesses()
+	if err != nil {
+		log.Printf("Failed to read tracked processes: %v", err)
+	}
+
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	paused := false
+	for _, engine := range engines {
+		paused = paused || engine.Paused
+	}
+	return &DaemonStatus{
+		PID:           os.Getpid(),
+		Daemon:        isDaemon,
+		Paused:        paused,
+		LastSetIds:    maps.Clone(Config.SavedUIState.LastSetIds),
+		ActiveProfile: Config.SavedUIState.ActiveProfile,
+		PowerPolicy:   currentPowerPolicy(),
+		GameMode:      isGameModeActive(),
+		Engines:       engines,
+	}
+}
+
+// Runs the daemon until it receives SIGINT or SIGTERM: restores the last set wallpapers,
+// runs the same watchers as the GUI (hotplug, power, game mode, resources), and serves the control protocol on daemonSocketPath().
+//
+// The wallpapers keep running after the daemon exits, like they do after the GUI is closed.
+func runDaemon(ctx context.Context) error {
+	socketPath := daemonSocketPath()
+	// also creates the fallback directory of the socket, if $XDG_RUNTIME_DIR is not set
+	if _, err := ensureRuntimeDir(); err != nil {
+		return fmt.Errorf("failed to create the runtime directory: %v", err)
+	}
+	if daemonRunning() {
+		return fmt.Errorf("a daemon is already listening on %s", socketPath)
+	}
+	// left behind by a daemon that did not exit cleanly
+	os.Remove(socketPath)
+
+	// so the socket is created with 0600 permissions, instead of being accessible by other users until it is chmodded
+	oldUmask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", socketPath)
+	syscall.Umask(oldUmask)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+	isDaemon = true
+	log.Printf("Daemon listening on %s", socketPath)
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	if err := loadWallpaperStats(); err != nil {
+		log.Printf("Error loading wallpaper stats: %v", err)
+	}
+	if err := reloadWallpaperData(); err != nil {
+		log.Printf("Error reloading wallpaper data: %v", err)
+	}
+	if len(Config.SavedUIState.LastSetIds) > 0 {
+		go func() {
+			if err := <-queueApply("restore", restoreWallpaper); err != nil {
+				log.Printf("Failed to restore last set wallpaper: %v", err)
+			}
+		}()
+	}
+
+	go watchOutputHotplug(ctx, 2*time.Second)
+	go watchPowerSupply(ctx, time.Duration(Config.PowerPolicy.Interval)*time.Second)
+	go watchGameModeProcesses(ctx, time.Duration(Config.GameMode.Interval)*time.Second)
+	go watchEngineResources(ctx, 2*time.Second, true)
+	go watchConfigFile(ctx, 2*time.Second)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+		go serveDaemonConnection(conn)
+	}
+
+	log.Println("Daemon stopping")
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+	return saveConfig()
+}
+
+// Answers the requests on the connection, one JSON object per line, until the client closes it.
+func serveDaemonConnection(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			request := DaemonRequest{}
+			response := DaemonResponse{}
+			if err := json.Unmarshal(line, &request); err != nil {
+				response = DaemonResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+			} else {
+				log.Printf("Daemon request: %s", request.Method)
+				response = handleControlRequest(request)
+			}
+			if err := encoder.Encode(response); err != nil {
+				log.Printf("Failed to send daemon response: %v", err)
+				return
+			}
+		}
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Printf("Failed to read daemon request: %v", err)
+			return
+		}
+	}
+}
+
+// Queues the apply in this process, or sends the request to the daemon if the GUI is using one, see UseDaemon.
+// The apply function is only used without a daemon.
+//
+// Meant for the GUI; it does not wait for the result.
+func applyOrForward(description string, method string, params any, apply func(ctx context.Context) error) {
+	if !UseDaemon {
+		queueApply(description, apply)
+		return
+	}
+
+	go func() {
+		updateGUIStatusText("Sent " + description + " to the daemon...")
+		status, err := callDaemon(method, params)
+		if err != nil && err.Error() == errApplySuperseded.Error() {
+			// a newer request updates the status text when it is done
+			return
+		} else if err != nil {
+			log.Printf("Daemon request (%s) failed: %v", description, err)
+			updateGUIStatusText("Failed to apply " + description + ": " + err.Error())
+			return
+		}
+		glib.IdleAdd(func() {
+			Config.SavedUIState.LastSetIds = status.LastSetIds
+			Config.SavedUIState.ActiveProfile = status.ActiveProfile
+		})
+		updateGUIStatusText("Double-click a wallpaper to apply it.")
+		updateGUIPauseState()
+	}()
+}
+
+// Saves the config of the GUI. With a daemon, the wallpapers it applied are kept,
+// and it is asked to reload the config afterwards, so it has the changes made in the GUI.
+func saveConfigWithDaemon() {
+	if !UseDaemon {
+		saveConfig()
+		return
+	}
+
+	if status, err := callDaemon("status", nil); err != nil {
+		log.Printf("Failed to get the daemon status: %v", err)
+	} else {
+		Config.SavedUIState.LastSetIds = status.LastSetIds
+		Config.SavedUIState.ActiveProfile = status.ActiveProfile
+	}
+	if err := saveConfig(); err != nil {
+		return
+	}
+	if _, err := callDaemon("reload", nil); err != nil {
+		log.Printf("Failed to reload the daemon config: %v", err)
+	}
+}
+
+// Prints the status, as a table of the running wallpapers, or as JSON.
+func printStatus(writer io.Writer, status DaemonStatus, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	}
+
+	if status.Daemon {
+		fmt.Fprintf(writer, "Daemon: running (PID %d)\n", status.PID)
+	} else {
+		fmt.Fprintln(writer, "Daemon: not running")
+	}
+	fmt.Fprintf(writer, "Paused: %s\n", yesNo(status.Paused))
+	if status.ActiveProfile != "" {
+		fmt.Fprintf(writer, "Profile: %s\n", status.ActiveProfile)
+	}
+	if status.Daemon {
+		fmt.Fprintf(writer, "Power policy: %s\n", status.PowerPolicy)
+		fmt.Fprintf(writer, "Game mode: %s\n", yesNo(status.GameMode))
+	}
+	fmt.Fprintln(writer)
+
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "OUTPUT\tWALLPAPER\tPID\tPAUSED")
+	for _, output := range slices.Sorted(maps.Keys(status.LastSetIds)) {
+		pid := "-"
+		paused := "-"
+		for _, engine := range status.Engines {
+			if engine.Output == output {
+				pid = strconv.Itoa(engine.PID)
+				paused = yesNo(engine.Paused)
+			}
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", output, status.LastSetIds[output], pid, paused)
+	}
+	return table.Flush()
+}
+
+// Returns "yes" or "no" for the given value, for the status table.
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
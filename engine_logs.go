@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// How often the size of a running engine's log is checked, see watchEngineLogSize.
+var EngineLogCheckInterval = 5 * time.Second
+
+// Returns the directory the engine logs of the given wallpaper are written to, e.g. ~/.cache/linux-wallpaperengine-helper/logs/<wallpaper_id>
+func engineLogDir(wallpaperId string) string {
+	return filepath.Join(CacheDir, "logs", wallpaperId)
+}
+
+// Starts linux-wallpaperengine with the given command in its own GPID, like startDetachedProcess.
+//
+// If Config.Constants.EngineLogs is true, the output of the engine is written to a new log file of the wallpaper,
+// named after the time it was started and the output, e.g. 20250101-120000-HDMI-A-1.log.
+// The log is a plain file rather than a pipe, so the engine keeps running when the helper exits.
+// Otherwise, it falls back to startDetachedProcess, which follows Config.Constants.DiscardProcessLogs.
+//
+// Returns the started exec.Cmd, and the path to the log file, or an empty string if there is none.
+func startLoggedEngine(command []string, output string, wallpaperId string) (*exec.Cmd, string, error) {
+	if !Config.Constants.EngineLogs {
+		cmd, err := startDetachedProcess(command...)
+		return cmd, "", err
+	}
+
+	logDir, err := ensureDir(engineLogDir(wallpaperId))
+	if err != nil {
+		log.Printf("Failed to ensure engine log directory, not logging: %v", err)
+		cmd, err := startDetachedProcess(command...)
+		return cmd, "", err
+	}
+	pruneEngineLogs(wallpaperId)
+
+	logPath := filepath.Join(logDir, time.Now().Format("20060102-150405")+"-"+output+".log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Failed to create engine log %s, not logging: %v", logPath, err)
+		cmd, err := startDetachedProcess(command...)
+		return cmd, "", err
+	}
+	defer logFile.Close()
+	fmt.Fprintf(logFile, "# %s\n", formatCommand(command))
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Start(); err != nil {
+		return nil, "", fmt.Errorf("error starting detached process: %v", err)
+	}
+	log.Printf("Detached process started with PID: %d, logging to %s", cmd.Process.Pid, logPath)
+
+	go watchEngineLogSize(logPath, cmd.Process.Pid)
+	return cmd, logPath, nil
+}
+
+// Keeps the engine log at logPath under Config.Constants.EngineLogMaxSize KiB while the process with the given PID runs.
+//
+// When the log grows over the limit, its contents are moved to <log>.1 (replacing the previous one),
+// and the log is truncated. The engine opened it with O_APPEND, so it keeps writing at the new end.
+func watchEngineLogSize(logPath string, pid int) {
+	ticker := time.NewTicker(EngineLogCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !isProcessRunning(pid) {
+			return
+		}
+
+		info, err := os.Stat(logPath)
+		if err != nil {
+			log.Printf("Stopped watching engine log %s: %v", logPath, err)
+			return
+		}
+		if info.Size() <= Config.Constants.EngineLogMaxSize*1024 {
+			continue
+		}
+
+		if err := rotateEngineLog(logPath); err != nil {
+			log.Printf("Failed to rotate engine log %s: %v", logPath, err)
+		}
+	}
+}
+
+// Copies the engine log to <log>.1 and truncates it, see watchEngineLogSize.
+func rotateEngineLog(logPath string) error {
+	source, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	dest, err := os.Create(logPath + ".1")
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if _, err := io.Copy(dest, source); err != nil {
+		return err
+	}
+	return os.Truncate(logPath, 0)
+}
+
+// Returns the engine logs of the given wallpaper, newest first.
+func listEngineLogs(wallpaperId string) []string {
+	logs, err := filepath.Glob(filepath.Join(engineLogDir(wallpaperId), "*.log"))
+	if err != nil {
+		return []string{}
+	}
+	// the names start with the time the engine was started, so they sort chronologically
+	slices.Sort(logs)
+	slices.Reverse(logs)
+	return logs
+}
+
+// Removes the oldest engine logs of the given wallpaper,
+// leaving room for one more log within Config.Constants.EngineLogKeep.
+func pruneEngineLogs(wallpaperId string) {
+	logs := listEngineLogs(wallpaperId)
+	keep := max(int(Config.Constants.EngineLogKeep)-1, 0)
+	if len(logs) <= keep {
+		return
+	}
+
+	for _, logPath := range logs[keep:] {
+		for _, file := range []string{logPath, logPath + ".1"} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove old engine log %s: %v", file, err)
+			}
+		}
+	}
+}
+
+// Returns the path to the newest engine log of the given wallpaper, or an error if the wallpaper has no logs.
+func latestEngineLog(wallpaperId string) (string, error) {
+	logs := listEngineLogs(wallpaperId)
+	if len(logs) == 0 {
+		return "", fmt.Errorf("no engine logs found for wallpaper %s", wallpaperId)
+	}
+	return logs[0], nil
+}
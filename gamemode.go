@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What to do with the wallpapers while a game mode process is running, see Config.GameMode.Action.
+var GameModeActions = []string{"pause", "kill"}
+
+// Held while switching game mode or the power policy, so the watchers do not pause, resume or restore the wallpapers
+// at the same time. Guards pausedByGameMode, restoreAfterGameMode and pausedByPowerPolicy.
+//
+// It is held while waiting for the apply worker, so the apply functions must not take it.
+var policyMutex sync.Mutex
+
+// Guards gameModeActive and activePowerPolicy, which are read outside of policyMutex, e.g. by the apply worker and the daemon status.
+// It is only held to read or write the variables; writers hold policyMutex as well, so they can read it directly.
+var policyStateMutex sync.Mutex
+
+// Whether game mode is active, i.e. the wallpapers were paused or killed because of a running process.
+var gameModeActive bool = false
+
+// Whether the engines were paused by game mode, so only those are resumed afterwards.
+var pausedByGameMode bool = false
+
+// Whether a restore was skipped while game mode was active, so it is done when game mode ends, see deferRestoreForGameMode.
+var restoreAfterGameMode bool = false
+
+// Returns whether game mode is active, see gameModeActive.
+func isGameModeActive() bool {
+	policyStateMutex.Lock()
+	defer policyStateMutex.Unlock()
+	return gameModeActive
+}
+
+// Returns whether restoring the wallpapers has to wait until game mode ends, as it would start them in the middle of a game,
+// e.g. after an output change or when switching between battery and AC. Game mode restores them when it ends instead.
+//
+// Must be called while holding policyMutex, so game mode does not end in between.
+func deferRestoreForGameMode() bool {
+	if !gameModeActive {
+		return false
+	}
+	log.Println("Game mode active, restoring the wallpapers when it ends")
+	restoreAfterGameMode = true
+	return true
+}
+
+// Returns the first running process matching Config.GameMode.Processes (by name, like pidof)
+// or Config.GameMode.Patterns (regular expressions matched against the full command line).
+//
+// The helper itself and the engines it started are never matched.
+func findGameModeProcess(processes []ProcessInfo) (ProcessInfo, bool) {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range Config.GameMode.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Ignoring invalid game mode pattern %q: %v", pattern, err)
+			continue
+		}
+		patterns = append(patterns, compiled)
+	}
+
+	for _, process := range processes {
+		if process.PID == os.Getpid() || processHasName(process, "linux-wallpaperengine") {
+			continue
+		}
+
+		for _, name := range Config.GameMode.Processes {
+			if processHasName(process, name) {
+				return process, true
+			}
+		}
+
+		if len(process.Cmdline) == 0 {
+			continue
+		}
+		cmdline := strings.Join(process.Cmdline, " ")
+		for _, pattern := range patterns {
+			if pattern.MatchString(cmdline) {
+				return process, true
+			}
+		}
+	}
+	return ProcessInfo{}, false
+}
+
+// Pauses or kills the wallpapers (see Config.GameMode.Action) when a game mode process started,
+// and resumes or restores them when none are running anymore.
+//
+// Returns an error if the wallpapers could not be paused/killed/resumed/restored, so it can be retried.
+func setGameModeActive(active bool) error {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+
+	if active == gameModeActive {
+		return nil
+	}
+
+	if active {
+		switch Config.GameMode.Action {
+		case "kill":
+			updateGUIStatusText("Game mode: stopping wallpapers...")
+			// queued, so it does not race with a wallpaper being applied
+			err := <-queueApply("stop for game mode", func(ctx context.Context) error {
+				stopSupervisingEngines()
+				return tryKillTrackedProcesses()
+			})
+			if err != nil && !errors.Is(err, errApplySuperseded) {
+				return err
+			}
+		default:
+			if enginesPaused() {
+				// paused by the user, so leave it paused afterwards
+				break
+			}
+			updateGUIStatusText("Game mode: pausing wallpapers...")
+			if err := setEnginesPaused(true); err != nil {
+				return err
+			}
+			pausedByGameMode = true
+		}
+		policyStateMutex.Lock()
+		gameModeActive = true
+		policyStateMutex.Unlock()
+		return nil
+	}
+
+	if pausedByGameMode {
+		updateGUIStatusText("Game mode ended, resuming wallpapers...")
+		if err := setEnginesPaused(false); err != nil {
+			return err
+		}
+		pausedByGameMode = false
+	}
+	if (Config.GameMode.Action == "kill" || restoreAfterGameMode) && len(Config.SavedUIState.LastSetIds) > 0 {
+		updateGUIStatusText("Game mode ended, restoring wallpapers...")
+		if err := <-queueApply("restore after game mode", restoreWallpaper); err != nil && !errors.Is(err, errApplySuperseded) {
+			return err
+		}
+	}
+	restoreAfterGameMode = false
+	policyStateMutex.Lock()
+	gameModeActive = false
+	policyStateMutex.Unlock()
+	updateGUIStatusText("Double-click a wallpaper to apply it.")
+	return nil
+}
+
+// Scans /proc every interval until ctx is cancelled, and activates game mode while a configured process is running,
+// see findGameModeProcess and setGameModeActive.
+//
+// Meant to be run as a goroutine.
+func watchGameModeProcesses(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// checked here instead of before starting, so toggling it in the options takes effect immediately
+		if Config.GameMode.Enabled || isGameModeActive() {
+			processes, err := listProcesses()
+			if err != nil {
+				log.Printf("Failed to list processes for game mode: %v", err)
+			} else {
+				process, found := findGameModeProcess(processes)
+				active := found && Config.GameMode.Enabled
+				if active && !isGameModeActive() {
+					log.Printf("Game mode process running: %s (PID: %d)", process.Name, process.PID)
+				}
+				if err := setGameModeActive(active); err != nil {
+					log.Printf("Failed to switch game mode, retrying later: %v", err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
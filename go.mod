@@ -6,12 +6,12 @@ require (
 	github.com/diamondburned/gotk4/pkg v0.3.1
 	github.com/disintegration/imaging v1.6.2
 	github.com/pelletier/go-toml/v2 v2.2.4
+	github.com/urfave/cli/v3 v3.3.8
+	golang.org/x/image v0.29.0
 )
 
 require (
 	github.com/KarpelesLab/weak v0.1.1 // indirect
-	github.com/urfave/cli/v3 v3.3.8 // indirect
 	go4.org/unsafe/assume-no-moving-gc v0.0.0-20231121144256-b99613f794b6 // indirect
-	golang.org/x/image v0.29.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
 )
@@ -6,7 +6,9 @@ import (
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 // Helper function to resolve a path string.
@@ -90,6 +92,63 @@ func ensureCacheDir() (string, error) {
 	return ensureDir(wallpaperCacheDir)
 }
 
+// Helper function to ensure the runtime directory exists.
+// Uses the $XDG_RUNTIME_DIR environment variable or defaults to a per-user directory in the temp directory, see fallbackRuntimeDir, and
+// appends "linux-wallpaperengine-helper" to it.
+//
+// Creates the runtime directory if it does not exist, only accessible by the current user, see ensurePrivateDir.
+// Returns the path to the runtime directory or an error if it fails.
+func ensureRuntimeDir() (string, error) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return ensurePrivateDir(fallbackRuntimeDir())
+	}
+
+	return ensurePrivateDir(filepath.Join(runtimeDir, "linux-wallpaperengine-helper"))
+}
+
+// Returns the runtime directory used without $XDG_RUNTIME_DIR, "linux-wallpaperengine-helper-<uid>" in the temp directory.
+// As its name is predictable, it must be checked with checkPrivateDir before it is used.
+func fallbackRuntimeDir() string {
+	return filepath.Join(os.TempDir(), "linux-wallpaperengine-helper-"+strconv.Itoa(os.Getuid()))
+}
+
+// Creates the directory with 0700 permissions if it does not exist, and ensures only the current user can write to it.
+// Returns the path to the directory, or an error if it is not a directory owned by the current user, see checkPrivateDir.
+func ensurePrivateDir(dir string) (string, error) {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+	// created with looser permissions by older versions
+	if err := os.Chmod(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Returns an error if the path is not a directory owned by the current user, e.g. a symlink or a directory created by another user,
+// or if other users can write to it.
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", dir)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by other users", dir)
+	}
+	return nil
+}
+
 // Helper function to ensure the config directory exists.
 // Uses the $XDG_CONFIG_HOME environment variable or defaults to $HOME/.config, and appends "linux-wallpaperengine-helper" to it.
 //
@@ -130,6 +189,29 @@ func replaceVariablesInString(input string, variables map[string]string) string
 	return output
 }
 
+// Quotes the string for use as a single argument in a POSIX shell command.
+//
+// Strings that only contain characters that are safe in a shell are returned as is,
+// anything else is wrapped in single quotes, escaping any single quotes inside it.
+func shellQuote(input string) string {
+	if input != "" && shellSafeRegex.MatchString(input) {
+		return input
+	}
+	return "'" + strings.ReplaceAll(input, "'", `'\''`) + "'"
+}
+
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Formats a command (the binary followed by its arguments) as a string that can be pasted into a shell,
+// quoting every argument with shellQuote.
+func formatCommand(command []string) string {
+	quoted := make([]string, len(command))
+	for i, argument := range command {
+		quoted[i] = shellQuote(argument)
+	}
+	return strings.Join(quoted, " ")
+}
+
 // Escapes special characters in a string for use in GTK markup.
 func escapeMarkup(input string) string {
 	input = strings.ReplaceAll(input, "&", "&amp;")
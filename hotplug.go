@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// How long to wait after the connected outputs changed before re-applying, so the compositor has time to set up the new outputs.
+const HotplugSettleDelay = 2 * time.Second
+
+// Returns the sorted names of the connected DRM connectors in the given sysfs directory.
+func connectedDRMOutputs(root string) ([]string, error) {
+	monitors, err := readDRMMonitors(root)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, monitor := range monitors {
+		names = append(names, monitor.Name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Polls the DRM connector status in DRMSysfsRoot every interval until ctx is cancelled.
+//
+// When the set of connected outputs changes and Config.Constants.WatchHotplug is enabled,
+// the saved wallpapers are re-applied with restoreWallpaper(), which only starts the configured outputs that are connected.
+//
+// Meant to be run as a goroutine.
+func watchOutputHotplug(ctx context.Context, interval time.Duration) {
+	lastOutputs, err := connectedDRMOutputs(DRMSysfsRoot)
+	if err != nil {
+		log.Printf("Failed to read connected outputs, hotplug detection disabled: %v", err)
+		return
+	}
+	log.Printf("Watching for output changes, currently connected: %v", lastOutputs)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		outputs, err := connectedDRMOutputs(DRMSysfsRoot)
+		if err != nil {
+			log.Printf("Failed to read connected outputs: %v", err)
+			continue
+		}
+		if slices.Equal(outputs, lastOutputs) {
+			continue
+		}
+
+		log.Printf("Connected outputs changed from %v to %v", lastOutputs, outputs)
+		lastOutputs = outputs
+
+		// checked here instead of before starting, so toggling it in the options takes effect immediately
+		if !Config.Constants.WatchHotplug {
+			continue
+		}
+
+		policyMutex.Lock()
+		deferred := deferRestoreForGameMode()
+		policyMutex.Unlock()
+		if deferred {
+			continue
+		}
+
+		updateGUIStatusText("Outputs changed, re-applying wallpapers...")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(HotplugSettleDelay):
+		}
+
+		if err := <-queueApply("restore after output change", restoreWallpaper); err != nil && !errors.Is(err, errApplySuperseded) {
+			log.Printf("Failed to re-apply wallpapers after output change: %v", err)
+			updateGUIStatusText("Failed to re-apply wallpapers after output change.")
+		}
+	}
+}
@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	_ "image/gif"  // For gif decoder
 	_ "image/jpeg" // For jpeg decoder
 	_ "image/png"  // For png decoder
 	"log"
 	"os"
 	"path"
-	"time"
+	"slices"
+	"strings"
 
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
@@ -32,6 +34,7 @@ func main() {
 
 	configFile := path.Join(ConfigDir, "config.toml")
 	readOrCreateConfig(configFile, Config)
+	validateConfig()
 
 	// ensure ~/.cache/linux-wallpaperengine-helper
 	CacheDir, err = ensureCacheDir()
@@ -52,66 +55,490 @@ func main() {
 					Name:    "restore",
 					Aliases: []string{"r"},
 					Usage:   "Restore the last set wallpaper set in the config",
+					Flags:   postProcessingFlags(),
+					Action: func(ctx context.Context, c *cli.Command) error {
+						if daemonRunning() {
+							log.Println("Daemon running, asking it to restore")
+							if _, err := callDaemon("restore", RestoreParams{PostProcessing: postProcessingOverrides(c)}); err != nil {
+								log.Println("Failed to restore last set wallpaper:", err)
+								return cli.Exit("Failed to restore last set wallpaper.", 1)
+							}
+							return nil
+						}
+						if err := <-queueApply("restore", restoreWallpaper); err != nil {
+							log.Println("Failed to restore last set wallpaper:", err)
+							return cli.Exit("Failed to restore last set wallpaper.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "apply",
+					Aliases:   []string{"a"},
+					Usage:     "Apply a wallpaper by its workshop ID, its title, or the path to its directory",
+					ArgsUsage: "<id|title|path>",
+					Flags: append([]cli.Flag{
+						&cli.StringSliceFlag{
+							Name:  "output",
+							Usage: "The outputs to apply the wallpaper to, e.g. --output=HDMI-A-1; defaults to the target output",
+						},
+						&cli.Int64Flag{
+							Name:  "volume",
+							Usage: "The volume to apply the wallpaper with, 0-100; defaults to the volume in the config",
+						},
+					}, postProcessingFlags()...),
+					Action: func(ctx context.Context, c *cli.Command) error {
+						query := c.Args().First()
+						if query == "" {
+							return cli.Exit("Missing the wallpaper to apply, e.g. apply 1234567890.", 1)
+						}
+
+						wallpaperPath, candidates, err := resolveWallpaperQuery(query)
+						if len(candidates) > 1 {
+							printWallpaperCandidates(os.Stdout, query, candidates)
+							return cli.Exit("", 1)
+						} else if err != nil {
+							log.Printf("Error finding the wallpaper: %v", err)
+							return cli.Exit(fmt.Sprintf("Failed to find the wallpaper: %v", err), 1)
+						}
+
+						volume := Config.SavedUIState.Volume
+						if c.IsSet("volume") {
+							volume = max(0, min(100, c.Int64("volume")))
+						}
+						outputs := outputsOrTarget(c.StringSlice("output"))
+
+						if daemonRunning() {
+							log.Println("Daemon running, asking it to apply")
+							params := ApplyParams{Wallpaper: wallpaperPath, Outputs: outputs, Volume: &volume, PostProcessing: postProcessingOverrides(c)}
+							if _, err := callDaemon("apply", params); err != nil {
+								log.Printf("Error applying wallpaper: %v", err)
+								return cli.Exit("Failed to apply the wallpaper.", 1)
+							}
+							return nil
+						}
+
+						err = <-queueApply("wallpaper "+path.Base(wallpaperPath), func(ctx context.Context) error {
+							return applyWallpaper(ctx, wallpaperPath, float64(volume), outputs...)
+						})
+						if err != nil {
+							log.Printf("Error applying wallpaper: %v", err)
+							return cli.Exit("Failed to apply the wallpaper.", 1)
+						}
+						if err := saveAppliedState(); err != nil {
+							log.Printf("Error saving the applied wallpaper: %v", err)
+						}
+						return nil
+					},
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"ls"},
+					Usage:   "List the wallpapers in the wallpaper directory",
 					Flags: []cli.Flag{
-						&cli.BoolWithInverseFlag{
-							Name:     "post-processing",
-							Usage:    "Override post-processing step, e.g. --post-processing or --no-post-processing. Setting this to false will skip post-processing entirely.",
-							Category: "Post Processing",
-							Required: false,
-							OnlyOnce: true,
-							Action: func(ctx context.Context, c *cli.Command, value bool) error {
-								log.Printf("PostProcessing.Enabled set to %v", value)
-								Config.PostProcessing.Enabled = value
-								return nil
+						&cli.BoolFlag{
+							Name:  "favorites",
+							Usage: "Only list wallpapers marked as favorite",
+						},
+						&cli.BoolFlag{
+							Name:  "broken",
+							Usage: "Only list wallpapers marked as broken",
+						},
+						&cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Only list wallpapers with the tag, e.g. --tag=Anime; can be given multiple times",
+						},
+						&cli.StringFlag{
+							Name:  "search",
+							Usage: "Only list wallpapers whose title, description or tags contain the text",
+						},
+						&cli.StringFlag{
+							Name:  "sort",
+							Usage: "Sort by " + strings.Join(SortModes, ", ") + "; defaults to the sort_by config",
+						},
+						&cli.StringFlag{
+							Name:    "format",
+							Aliases: []string{"f"},
+							Value:   "table",
+							Usage:   "Print as " + strings.Join(ListFormats, ", ") + "; tsv prints id, title, type, tags, favorite, broken and path, nul only the IDs",
+						},
+					},
+					Action: func(ctx context.Context, c *cli.Command) error {
+						sortBy := Config.SavedUIState.SortBy
+						if c.IsSet("sort") {
+							sortBy = c.String("sort")
+						}
+						if !slices.Contains(SortModes, sortBy) {
+							return cli.Exit(fmt.Sprintf("Unknown sort %q, expected one of %s.", sortBy, strings.Join(SortModes, ", ")), 1)
+						}
+						if !slices.Contains(ListFormats, c.String("format")) {
+							return cli.Exit(fmt.Sprintf("Unknown format %q, expected one of %s.", c.String("format"), strings.Join(ListFormats, ", ")), 1)
+						}
+
+						items, err := scanWallpapers()
+						if err != nil {
+							log.Printf("Error reading wallpapers: %v", err)
+							return cli.Exit("Failed to read the wallpapers.", 1)
+						}
+						if strings.HasPrefix(sortBy, "cpu_") {
+							if err := loadWallpaperStats(); err != nil {
+								log.Printf("Error loading wallpaper stats: %v", err)
+							}
+						}
+
+						items = filterWallpaperItems(items, WallpaperFilter{
+							FavoritesOnly: c.Bool("favorites"),
+							BrokenOnly:    c.Bool("broken"),
+							Tags:          c.StringSlice("tag"),
+							Search:        c.String("search"),
+						})
+						sortWallpapers(items, sortBy)
+						if err := printWallpaperList(os.Stdout, items, c.String("format")); err != nil {
+							log.Printf("Error printing wallpapers: %v", err)
+							return cli.Exit("Failed to print the wallpapers.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "info",
+					Aliases:   []string{"i"},
+					Usage:     "Show everything known about a wallpaper, including the command it is applied with",
+					ArgsUsage: "<id|title>",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the info as JSON",
+						},
+					},
+					Action: func(ctx context.Context, c *cli.Command) error {
+						query := c.Args().First()
+						if query == "" {
+							return cli.Exit("Missing the wallpaper, e.g. info 1234567890.", 1)
+						}
+						if err := reloadWallpaperData(); err != nil {
+							log.Printf("Error reading wallpapers: %v", err)
+							return cli.Exit("Failed to read the wallpapers.", 1)
+						}
+
+						matches := findWallpapers(query)
+						if len(matches) > 1 {
+							printWallpaperCandidates(os.Stdout, query, matches)
+							return cli.Exit("", 1)
+						} else if len(matches) == 0 {
+							return cli.Exit(fmt.Sprintf("No wallpaper matches %q.", query), 1)
+						}
+
+						if err := printWallpaperInfo(os.Stdout, describeWallpaper(matches[0]), c.Bool("json")); err != nil {
+							log.Printf("Error printing wallpaper info: %v", err)
+							return cli.Exit("Failed to print the wallpaper info.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "favorite",
+					Usage: "Add, remove or list favorite wallpapers",
+					Commands: []*cli.Command{
+						{
+							Name:      "add",
+							Usage:     "Mark wallpapers as favorite",
+							ArgsUsage: "<id|title>...",
+							Action: func(ctx context.Context, c *cli.Command) error {
+								return setMarksFromCLI(c.Args().Slice(), Config.SavedUIState.Favorites, func(id string) error { return setFavorite(id, true) })
 							},
 						},
-						&cli.DurationFlag{
-							Name:     "artificial-delay",
-							Aliases:  []string{"delay"},
-							Usage:    "Override artificial delay in seconds to wait before post-processing, e.g. --artificial-delay=2s",
-							Category: "Post Processing",
-							Action: func(ctx context.Context, c *cli.Command, value time.Duration) error {
-								log.Printf("PostProcessing.ArtificialDelay set to %vs", int64(value.Seconds()))
-								Config.PostProcessing.ArtificialDelay = int64(value.Seconds())
-								return nil
+						{
+							Name:      "remove",
+							Aliases:   []string{"rm"},
+							Usage:     "Unmark favorite wallpapers",
+							ArgsUsage: "<id|title>...",
+							Action: func(ctx context.Context, c *cli.Command) error {
+								return setMarksFromCLI(c.Args().Slice(), Config.SavedUIState.Favorites, func(id string) error { return setFavorite(id, false) })
 							},
 						},
-						&cli.StringSliceFlag{
-							Name:      "screenshot",
-							Usage:     "Override screenshot files to copy output screenshot to, e.g. --screenshot=/path/to/screenshot.png --screenshot=/path/to/another.jpg",
-							TakesFile: true,
-							Category:  "Post Processing",
-							Action: func(ctx context.Context, c *cli.Command, value []string) error {
-								log.Printf("PostProcessing.ScreenshotFiles set to %v", value)
-								Config.PostProcessing.ScreenshotFiles = value
+						{
+							Name:    "list",
+							Aliases: []string{"ls"},
+							Usage:   "List the favorite wallpapers",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "format",
+									Aliases: []string{"f"},
+									Value:   "table",
+									Usage:   "Print as " + strings.Join(ListFormats, ", "),
+								},
+							},
+							Action: func(ctx context.Context, c *cli.Command) error {
+								if err := printMarkedWallpapers(Config.SavedUIState.Favorites, c.String("format")); err != nil {
+									log.Printf("Error listing favorites: %v", err)
+									return cli.Exit("Failed to list the favorites.", 1)
+								}
 								return nil
 							},
 						},
-						&cli.StringFlag{
-							Name:     "post-command",
-							Aliases:  []string{"command"},
-							Usage:    "Override post-command to run, e.g. --post-command='your-command'",
-							Category: "Post Processing",
-							Action: func(ctx context.Context, c *cli.Command, value string) error {
-								Config.PostProcessing.PostCommand = value
+					},
+				},
+				{
+					Name:  "broken",
+					Usage: "Add, remove, list or reset wallpapers marked as broken",
+					Commands: []*cli.Command{
+						{
+							Name:      "add",
+							Usage:     "Mark wallpapers as broken",
+							ArgsUsage: "<id|title>...",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:  "reason",
+									Usage: "Why the wallpapers are broken, shown in the app",
+								},
+							},
+							Action: func(ctx context.Context, c *cli.Command) error {
+								return setMarksFromCLI(c.Args().Slice(), Config.SavedUIState.Broken, func(id string) error { return setBroken(id, true, c.String("reason")) })
+							},
+						},
+						{
+							Name:      "remove",
+							Aliases:   []string{"rm"},
+							Usage:     "Unmark broken wallpapers",
+							ArgsUsage: "<id|title>...",
+							Action: func(ctx context.Context, c *cli.Command) error {
+								return setMarksFromCLI(c.Args().Slice(), Config.SavedUIState.Broken, func(id string) error { return setBroken(id, false, "") })
+							},
+						},
+						{
+							Name:    "list",
+							Aliases: []string{"ls"},
+							Usage:   "List the wallpapers marked as broken",
+							Flags: []cli.Flag{
+								&cli.StringFlag{
+									Name:    "format",
+									Aliases: []string{"f"},
+									Value:   "table",
+									Usage:   "Print as " + strings.Join(ListFormats, ", "),
+								},
+							},
+							Action: func(ctx context.Context, c *cli.Command) error {
+								if err := printMarkedWallpapers(Config.SavedUIState.Broken, c.String("format")); err != nil {
+									log.Printf("Error listing broken wallpapers: %v", err)
+									return cli.Exit("Failed to list the broken wallpapers.", 1)
+								}
 								return nil
 							},
 						},
-						&cli.BoolWithInverseFlag{
-							Name:     "swww",
-							Usage:    "Override whether to set the wallpaper using swww after applying the wallpaper, e.g. --swww or --no-swww",
-							Category: "Post Processing",
-							Action: func(ctx context.Context, c *cli.Command, value bool) error {
-								log.Printf("PostProcessing.SetSWWW set to %v", value)
-								Config.PostProcessing.SetSWWW = value
+						{
+							Name:  "reset",
+							Usage: "Unmark every broken wallpaper",
+							Action: func(ctx context.Context, c *cli.Command) error {
+								if err := resetBroken(); err != nil {
+									log.Printf("Error resetting broken wallpapers: %v", err)
+									return cli.Exit("Failed to reset the broken wallpapers.", 1)
+								}
 								return nil
 							},
 						},
 					},
+				},
+				{
+					Name:    "monitors",
+					Aliases: []string{"m"},
+					Usage:   "List the connected monitors (outputs) that wallpapers can be applied to",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the monitors as JSON instead of a table",
+						},
+					},
 					Action: func(ctx context.Context, c *cli.Command) error {
-						if err := restoreWallpaper(); err != nil {
-							log.Println("Failed to restore last set wallpaper:", err)
-							return cli.Exit("Failed to restore last set wallpaper.", 1)
+						if err := printMonitors(os.Stdout, c.Bool("json")); err != nil {
+							log.Printf("Error listing monitors: %v", err)
+							return cli.Exit("Failed to list monitors.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "pause",
+					Usage: "Pause the running wallpapers without closing them",
+					Action: func(ctx context.Context, c *cli.Command) error {
+						paused := true
+						if _, err := callControlMethod("pause", PauseParams{Paused: &paused}); err != nil {
+							log.Printf("Error pausing wallpapers: %v", err)
+							return cli.Exit("Failed to pause the wallpapers.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "resume",
+					Usage: "Resume the paused wallpapers",
+					Action: func(ctx context.Context, c *cli.Command) error {
+						paused := false
+						if _, err := callControlMethod("pause", PauseParams{Paused: &paused}); err != nil {
+							log.Printf("Error resuming wallpapers: %v", err)
+							return cli.Exit("Failed to resume the wallpapers.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "toggle-pause",
+					Usage: "Pause the running wallpapers, or resume them if they are paused",
+					Action: func(ctx context.Context, c *cli.Command) error {
+						status, err := callControlMethod("pause", PauseParams{})
+						if err != nil {
+							log.Printf("Error pausing/resuming wallpapers: %v", err)
+							return cli.Exit("Failed to pause/resume the wallpapers.", 1)
+						}
+						if status.Paused {
+							fmt.Println("paused")
+						} else {
+							fmt.Println("resumed")
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "random",
+					Usage: "Apply a random wallpaper that is not marked as broken, optionally picked from a filtered selection",
+					Flags: append([]cli.Flag{
+						&cli.BoolFlag{
+							Name:  "favorites-only",
+							Usage: "Only pick from wallpapers marked as favorite",
+						},
+						&cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Only pick from wallpapers with the tag, e.g. --tag=Anime; can be given multiple times",
+						},
+						&cli.StringFlag{
+							Name:  "type",
+							Usage: "Only pick from wallpapers of the type, e.g. --type=scene, video or web",
+						},
+						&cli.Int64Flag{
+							Name:  "exclude-recent",
+							Usage: "Do not pick any of the N most recently applied wallpapers, e.g. --exclude-recent=5",
+						},
+						&cli.StringFlag{
+							Name:  "search",
+							Usage: "Only pick from wallpapers whose title, description or tags contain the text",
+						},
+						&cli.StringSliceFlag{
+							Name:  "output",
+							Usage: "The outputs to apply the wallpaper to, e.g. --output=HDMI-A-1; defaults to the target output",
+						},
+					}, postProcessingFlags()...),
+					Action: func(ctx context.Context, c *cli.Command) error {
+						params := RandomParams{
+							Outputs:        outputsOrTarget(c.StringSlice("output")),
+							FavoritesOnly:  c.Bool("favorites-only"),
+							Tags:           c.StringSlice("tag"),
+							Type:           c.String("type"),
+							Search:         c.String("search"),
+							ExcludeRecent:  int(c.Int64("exclude-recent")),
+							PostProcessing: postProcessingOverrides(c),
+						}
+						if len(params.Outputs) == 0 {
+							return cli.Exit("No outputs configured to apply the wallpaper to.", 1)
+						}
+
+						if daemonRunning() {
+							log.Println("Daemon running, asking it to apply")
+							status, err := callDaemon("random", params)
+							if err != nil {
+								log.Printf("Error applying a random wallpaper: %v", err)
+								return cli.Exit(fmt.Sprintf("Failed to apply a random wallpaper: %v", err), 1)
+							}
+							fmt.Println(status.LastSetIds[params.Outputs[0]])
+							return nil
+						}
+
+						if err := applyFilteredRandomWallpaper(params); err != nil {
+							log.Printf("Error applying a random wallpaper: %v", err)
+							return cli.Exit(fmt.Sprintf("Failed to apply a random wallpaper: %v", err), 1)
+						}
+						if err := saveAppliedState(); err != nil {
+							log.Printf("Error saving the applied wallpaper: %v", err)
+						}
+						fmt.Println(Config.SavedUIState.LastSetIds[params.Outputs[0]])
+						return nil
+					},
+				},
+				{
+					Name:  "next",
+					Usage: "Apply the next wallpaper in the list, after the one on the target output",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:  "output",
+							Usage: "The outputs to apply the wallpaper to, e.g. --output=HDMI-A-1; defaults to the target output",
+						},
+					},
+					Action: func(ctx context.Context, c *cli.Command) error {
+						if _, err := callControlMethod("next", TargetParams{Outputs: c.StringSlice("output")}); err != nil {
+							log.Printf("Error applying the next wallpaper: %v", err)
+							return cli.Exit("Failed to apply the next wallpaper.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "previous",
+					Usage: "Apply the previous wallpaper in the list, before the one on the target output",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:  "output",
+							Usage: "The outputs to apply the wallpaper to, e.g. --output=HDMI-A-1; defaults to the target output",
+						},
+					},
+					Action: func(ctx context.Context, c *cli.Command) error {
+						if _, err := callControlMethod("previous", TargetParams{Outputs: c.StringSlice("output")}); err != nil {
+							log.Printf("Error applying the previous wallpaper: %v", err)
+							return cli.Exit("Failed to apply the previous wallpaper.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "status",
+					Usage: "Show the running wallpapers, and whether they are paused",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "json",
+							Usage: "Print the status as JSON",
+						},
+					},
+					Action: func(ctx context.Context, c *cli.Command) error {
+						status, err := callControlMethod("status", nil)
+						if err != nil {
+							log.Printf("Error getting the status: %v", err)
+							return cli.Exit("Failed to get the status.", 1)
+						}
+						if err := printStatus(os.Stdout, status, c.Bool("json")); err != nil {
+							return cli.Exit("Failed to print the status.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "daemon",
+					Usage: "Stay running in the background, owning the wallpapers, and accept requests on " + daemonSocketPath(),
+					Action: func(ctx context.Context, c *cli.Command) error {
+						if err := runDaemon(ctx); err != nil {
+							log.Printf("Daemon error: %v", err)
+							return cli.Exit("Failed to run the daemon.", 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "reload",
+					Usage: "Make the running daemon re-read the config and the wallpapers",
+					Action: func(ctx context.Context, c *cli.Command) error {
+						if !daemonRunning() {
+							return cli.Exit("The daemon is not running.", 1)
+						}
+						if _, err := callDaemon("reload", nil); err != nil {
+							log.Printf("Error reloading the daemon: %v", err)
+							return cli.Exit("Failed to reload the daemon.", 1)
 						}
 						return nil
 					},
@@ -119,9 +546,19 @@ func main() {
 				{
 					Name:    "kill",
 					Aliases: []string{"k"},
-					Usage:   "Kill any running linux-wallpaperengine process",
+					Usage:   "Kill the linux-wallpaperengine processes started by the helper",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "all",
+							Usage: "Kill every running linux-wallpaperengine process, including ones not started by the helper",
+						},
+					},
 					Action: func(ctx context.Context, c *cli.Command) error {
-						if err := tryKillProcesses("linux-wallpaperengine"); err != nil {
+						killProcesses := tryKillTrackedProcesses
+						if c.Bool("all") {
+							killProcesses = func() error { return tryKillProcesses("linux-wallpaperengine") }
+						}
+						if err := killProcesses(); err != nil {
 							log.Printf("Error trying to kill existing processes: %v", err)
 							return cli.Exit("Failed to kill existing processes.", 1)
 						}
@@ -139,7 +576,13 @@ func main() {
 		app.ConnectActivate(func() { activate(app) })
 
 		code := app.Run(os.Args)
-		saveConfig()
+		saveConfigWithDaemon()
+		// the stats are recorded by the GUI only without a daemon, see activate; os.Exit skips the save of watchEngineResources
+		if MainWindow != nil && !UseDaemon {
+			if err := saveWallpaperStats(); err != nil {
+				log.Printf("Failed to save wallpaper stats: %v", err)
+			}
+		}
 		os.Exit(code)
 	}
 }
@@ -1,15 +1,13 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"image"
 	"log"
 	"os"
 	"path"
 	"slices"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,11 +16,15 @@ import (
 	"github.com/diamondburned/gotk4/pkg/gdk/v4"
 	"github.com/diamondburned/gotk4/pkg/gdkpixbuf/v2"
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
+	glibv2 "github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"github.com/disintegration/imaging"
 )
 
 var MainWindow *gtk.ApplicationWindow = nil
+var OutputDropdown *gtk.DropDown = nil
+var PauseButton *gtk.ToggleButton = nil
+var ResourceText *gtk.Label = nil
 var ScrolledWindow *gtk.ScrolledWindow = nil
 var SearchQuery string = ""
 var SelectedWallpaperItemId string = ""
@@ -75,7 +77,7 @@ func activate(app *gtk.Application) {
 	searchBar.SetSearchMode(true)
 	topControlBar.Append(searchBar)
 
-	sortByModel := gtk.NewStringList([]string{"Date (desc)", "Date (asc)", "Name (asc)", "Name (desc)"})
+	sortByModel := gtk.NewStringList([]string{"Date (desc)", "Date (asc)", "Name (asc)", "Name (desc)", "Heaviest first", "Lightest first"})
 	sortByDropdown := gtk.NewDropDown(sortByModel, nil)
 	sortByDropdown.SetHAlign(gtk.AlignStart)
 	sortByDropdown.SetVAlign(gtk.AlignCenter)
@@ -91,6 +93,10 @@ func activate(app *gtk.Application) {
 			Config.SavedUIState.SortBy = "name_asc"
 		case 3:
 			Config.SavedUIState.SortBy = "name_desc"
+		case 4:
+			Config.SavedUIState.SortBy = "cpu_desc"
+		case 5:
+			Config.SavedUIState.SortBy = "cpu_asc"
 		default:
 			log.Printf("Unknown sort criteria index: %d, defaulting to date_desc", selectedIndex)
 			Config.SavedUIState.SortBy = "date_desc"
@@ -103,10 +109,37 @@ func activate(app *gtk.Application) {
 	randomButton.SetHAlign(gtk.AlignStart)
 	randomButton.SetVAlign(gtk.AlignCenter)
 	randomButton.Connect("clicked", func() {
-		applyRandomWallpaper()
+		applyOrForward("random wallpaper", "random", RandomParams{Outputs: targetOutputs()}, applyRandomWallpaper)
 	})
 	topControlBar.Append(randomButton)
 
+	PauseButton = gtk.NewToggleButtonWithLabel("Pause")
+	PauseButton.SetHAlign(gtk.AlignStart)
+	PauseButton.SetVAlign(gtk.AlignCenter)
+	PauseButton.SetTooltipText("Freeze the running wallpapers without closing them")
+	PauseButton.Connect("toggled", func() {
+		paused := PauseButton.Active()
+		if paused == enginesPaused() {
+			// the button was updated to match the state, see refreshPauseButton
+			return
+		}
+		go func() {
+			setPaused := setEnginesPaused
+			if UseDaemon {
+				setPaused = func(paused bool) error {
+					_, err := callDaemon("pause", PauseParams{Paused: &paused})
+					return err
+				}
+			}
+			if err := setPaused(paused); err != nil {
+				log.Printf("Error pausing/resuming wallpapers: %v", err)
+				updateGUIStatusText("Failed to pause/resume the wallpapers.")
+			}
+		}()
+	})
+	refreshPauseButton()
+	topControlBar.Append(PauseButton)
+
 	optionsButton := gtk.NewButtonWithLabel("Options")
 	optionsButton.SetHAlign(gtk.AlignEnd)
 	optionsButton.SetVAlign(gtk.AlignCenter)
@@ -173,6 +206,73 @@ func activate(app *gtk.Application) {
 	volumeContainer.Append(volumeSlider)
 	bottomControlBar.Append(volumeContainer)
 
+	ResourceText = gtk.NewLabel("")
+	ResourceText.SetHAlign(gtk.AlignStart)
+	ResourceText.SetVAlign(gtk.AlignCenter)
+	ResourceText.SetTooltipText("Resource usage of the running wallpapers")
+	ResourceText.AddCSSClass("dim-label")
+	bottomControlBar.Append(ResourceText)
+
+	outputContainer := gtk.NewBox(gtk.OrientationVertical, 0)
+	outputContainer.SetHAlign(gtk.AlignStart)
+	outputContainer.SetVAlign(gtk.AlignCenter)
+	outputLabel := gtk.NewLabel("Apply to")
+	outputLabel.SetHAlign(gtk.AlignCenter)
+	outputLabel.SetVAlign(gtk.AlignCenter)
+	OutputDropdown = gtk.NewDropDown(gtk.NewStringList([]string{}), nil)
+	OutputDropdown.SetHAlign(gtk.AlignCenter)
+	OutputDropdown.SetVAlign(gtk.AlignCenter)
+	OutputDropdown.Connect("notify::selected", func() {
+		selectedIndex := int(OutputDropdown.Selected())
+		if selectedIndex > 0 && selectedIndex <= len(Config.Constants.Outputs) {
+			Config.SavedUIState.TargetOutput = Config.Constants.Outputs[selectedIndex-1]
+		} else {
+			Config.SavedUIState.TargetOutput = ""
+		}
+	})
+	refreshOutputDropdown()
+	outputContainer.Append(outputLabel)
+	outputContainer.Append(OutputDropdown)
+	bottomControlBar.Append(outputContainer)
+
+	scalingContainer := gtk.NewBox(gtk.OrientationVertical, 0)
+	scalingContainer.SetHAlign(gtk.AlignStart)
+	scalingContainer.SetVAlign(gtk.AlignCenter)
+	scalingLabel := gtk.NewLabel("Scaling")
+	scalingLabel.SetHAlign(gtk.AlignCenter)
+	scalingLabel.SetVAlign(gtk.AlignCenter)
+	scalingDropdown := gtk.NewDropDown(gtk.NewStringList(ScalingModes), nil)
+	scalingDropdown.SetHAlign(gtk.AlignCenter)
+	scalingDropdown.SetVAlign(gtk.AlignCenter)
+	scalingDropdown.SetTooltipText("Default scaling mode for wallpapers without their own scaling mode")
+	scalingDropdown.SetSelected(uint(max(slices.Index(ScalingModes, Config.SavedUIState.Scaling), 0)))
+	scalingDropdown.Connect("notify::selected", func() {
+		Config.SavedUIState.Scaling = ScalingModes[scalingDropdown.Selected()]
+	})
+	scalingContainer.Append(scalingLabel)
+	disableIfUnsupported(scalingDropdown, "--scaling")
+	scalingContainer.Append(scalingDropdown)
+	bottomControlBar.Append(scalingContainer)
+
+	clampingContainer := gtk.NewBox(gtk.OrientationVertical, 0)
+	clampingContainer.SetHAlign(gtk.AlignStart)
+	clampingContainer.SetVAlign(gtk.AlignCenter)
+	clampingLabel := gtk.NewLabel("Clamping")
+	clampingLabel.SetHAlign(gtk.AlignCenter)
+	clampingLabel.SetVAlign(gtk.AlignCenter)
+	clampingDropdown := gtk.NewDropDown(gtk.NewStringList(ClampingModes), nil)
+	clampingDropdown.SetHAlign(gtk.AlignCenter)
+	clampingDropdown.SetVAlign(gtk.AlignCenter)
+	clampingDropdown.SetTooltipText("Default clamping mode for wallpapers without their own clamping mode")
+	clampingDropdown.SetSelected(uint(max(slices.Index(ClampingModes, Config.SavedUIState.Clamping), 0)))
+	clampingDropdown.Connect("notify::selected", func() {
+		Config.SavedUIState.Clamping = ClampingModes[clampingDropdown.Selected()]
+	})
+	clampingContainer.Append(clampingLabel)
+	disableIfUnsupported(clampingDropdown, "--clamping")
+	clampingContainer.Append(clampingDropdown)
+	bottomControlBar.Append(clampingContainer)
+
 	//ANCHOR - Wallpaper list
 	// This will contain the list of wallpapers
 
@@ -224,6 +324,11 @@ func activate(app *gtk.Application) {
 	ScrolledWindow.SetHAlign(gtk.AlignFill)
 	ScrolledWindow.SetChild(WallpaperList)
 
+	// needed before sorting, in case the grid is sorted by the heaviest wallpapers
+	if err := loadWallpaperStats(); err != nil {
+		log.Printf("Error loading wallpaper stats: %v", err)
+	}
+
 	if err := reloadWallpaperData(); err != nil {
 		log.Printf("Error reloading wallpaper data: %v", err)
 		showFrontError(err.Error())
@@ -241,6 +346,19 @@ func activate(app *gtk.Application) {
 	MainWindow.SetChild(vBox)
 	MainWindow.SetDefaultSize(800, 600)
 	MainWindow.SetVisible(true)
+
+	// a running daemon owns the wallpapers, so it watches them instead, and the GUI forwards its requests to it
+	UseDaemon = daemonRunning()
+	if UseDaemon {
+		log.Printf("Daemon running on %s, forwarding requests to it", daemonSocketPath())
+		updateGUIStatusText("Connected to the daemon. Double-click a wallpaper to apply it.")
+	} else {
+		go watchOutputHotplug(context.Background(), 2*time.Second)
+		go watchPowerSupply(context.Background(), time.Duration(Config.PowerPolicy.Interval)*time.Second)
+		go watchGameModeProcesses(context.Background(), time.Duration(Config.GameMode.Interval)*time.Second)
+	}
+	go watchEngineResources(context.Background(), 2*time.Second, !UseDaemon)
+	go watchConfigFile(context.Background(), 2*time.Second)
 }
 
 // Helper function to provide custom CSS to the entire application.
@@ -301,6 +419,55 @@ func updateGUIStatusText(message string) {
 	}
 }
 
+// Refreshes the wallpaper list (see refreshWallpaperDisplay) if the GUI is running.
+// Safe to call from goroutines, as the refresh runs on the main thread.
+func refreshGUIWallpaperDisplay() {
+	if WallpaperList != nil {
+		glib.IdleAdd(refreshWallpaperDisplay)
+	}
+}
+
+// Updates the PauseButton to match whether the engines are paused, see enginesPaused.
+func refreshPauseButton() {
+	paused := enginesPaused()
+	PauseButton.SetActive(paused)
+	if paused {
+		PauseButton.SetLabel("Resume")
+	} else {
+		PauseButton.SetLabel("Pause")
+	}
+}
+
+// Refreshes the PauseButton (see refreshPauseButton) if the GUI is running.
+// Safe to call from goroutines, as the refresh runs on the main thread.
+func updateGUIPauseState() {
+	if PauseButton != nil {
+		glib.IdleAdd(refreshPauseButton)
+	}
+}
+
+// Updates the resource usage text next to the volume slider with the given message.
+func updateGUIResourceText(message string) {
+	if ResourceText != nil {
+		glib.IdleAdd(func() {
+			ResourceText.SetText(message)
+		})
+	}
+}
+
+// Rebuilds the OutputDropdown items from Config.Constants.Outputs.
+//
+// Keeps Config.SavedUIState.TargetOutput selected if it is still configured, otherwise selects "All Outputs".
+func refreshOutputDropdown() {
+	targetOutput := Config.SavedUIState.TargetOutput
+
+	// setting the model resets the selection, which also resets the target output
+	OutputDropdown.SetModel(gtk.NewStringList(append([]string{"All Outputs"}, Config.Constants.Outputs...)))
+
+	selectedIndex := slices.Index(Config.Constants.Outputs, targetOutput) + 1
+	OutputDropdown.SetSelected(uint(selectedIndex))
+}
+
 // Reselects the previously selected wallpaper item in the WallpaperList.
 //
 // If `unselect` is true, it will force unselect all items if it is not found, or if SelectedWallpaperItemId is empty.
@@ -383,6 +550,24 @@ func showDetails(wallpaperItem *WallpaperItem) {
 		tagsLabel.SetMarginTop(4)
 		labelsBox.Append(tagsLabel)
 	}
+	if reason, ok := Config.SavedUIState.BrokenReasons[wallpaperItem.WallpaperID]; ok && wallpaperItem.IsBroken {
+		brokenLabel := gtk.NewLabel("Marked as broken: " + reason)
+		brokenLabel.AddCSSClass("error")
+		brokenLabel.SetHAlign(gtk.AlignStart)
+		brokenLabel.SetVAlign(gtk.AlignStart)
+		brokenLabel.SetMarginBottom(4)
+		brokenLabel.SetWrap(true)
+		brokenLabel.SetSelectable(true)
+		labelsBox.Append(brokenLabel)
+	}
+	if stats, ok := wallpaperStats(wallpaperItem.WallpaperID); ok {
+		statsLabel := gtk.NewLabel(fmt.Sprintf("Average usage: CPU %.0f%%, RAM %s", stats.AverageCPU, formatMegabytes(stats.AverageRSS)))
+		statsLabel.SetMarkup("<span size=\"small\">" + escapeMarkup(statsLabel.Text()) + "</span>")
+		statsLabel.SetHAlign(gtk.AlignStart)
+		statsLabel.SetVAlign(gtk.AlignStart)
+		statsLabel.SetMarginBottom(4)
+		labelsBox.Append(statsLabel)
+	}
 	if wallpaperItem.projectJson.Description != "" {
 		descriptionScrollable := gtk.NewScrolledWindow()
 		descriptionScrollable.SetPolicy(gtk.PolicyAutomatic, gtk.PolicyAutomatic)
@@ -403,10 +588,125 @@ func showDetails(wallpaperItem *WallpaperItem) {
 
 	WallpaperPropertiesBox.Append(thumbnail)
 	WallpaperPropertiesBox.Append(labelsBox)
+	if propertiesEditor := createPropertiesEditor(wallpaperItem); propertiesEditor != nil {
+		WallpaperPropertiesBox.Append(propertiesEditor)
+	}
 	WallpaperPropertiesBox.SetVisible(true)
 	log.Printf("Showing details for wallpaper: %s", wallpaperItem.WallpaperID)
 }
 
+// Creates the editor for the user properties of the wallpaper, shown next to its details.
+//
+// Every property type in EditablePropertyTypes gets a matching widget, changing it saves an override in Config.Wallpapers.
+// The overrides are passed to linux-wallpaperengine the next time the wallpaper is applied.
+// Returns nil if the wallpaper has no editable properties.
+func createPropertiesEditor(wallpaperItem *WallpaperItem) *gtk.ScrolledWindow {
+	wallpaperId := wallpaperItem.WallpaperID
+
+	propertiesGrid := gtk.NewGrid()
+	propertiesGrid.SetRowSpacing(4)
+	propertiesGrid.SetColumnSpacing(8)
+	propertiesGrid.SetMarginTop(4)
+	propertiesGrid.SetMarginBottom(4)
+	propertiesGrid.SetMarginEnd(10)
+
+	row := 0
+	for _, property := range wallpaperItem.projectJson.Properties {
+		if !slices.Contains(EditablePropertyTypes, property.Type) {
+			continue
+		}
+		value := propertyValue(wallpaperId, property)
+
+		var propertyWidget gtk.Widgetter
+		switch property.Type {
+		case "slider":
+			step := property.Step
+			if step <= 0 {
+				step = 1
+			}
+			slider := gtk.NewScaleWithRange(gtk.OrientationHorizontal, property.Min, property.Max, step)
+			slider.SetSizeRequest(160, -1)
+			slider.SetDrawValue(true)
+			if step < 1 {
+				slider.SetDigits(2)
+			}
+			if parsedValue, err := strconv.ParseFloat(value, 64); err == nil {
+				slider.SetValue(parsedValue)
+			}
+			slider.Connect("value-changed", func(slider *gtk.Scale) {
+				setPropertyOverride(wallpaperId, property.Name, strconv.FormatFloat(slider.Value(), 'f', -1, 64))
+			})
+			propertyWidget = slider
+		case "bool":
+			checkButton := gtk.NewCheckButton()
+			checkButton.SetActive(value == "true" || value == "1")
+			checkButton.Connect("toggled", func() {
+				setPropertyOverride(wallpaperId, property.Name, strconv.FormatBool(checkButton.Active()))
+			})
+			propertyWidget = checkButton
+		case "color":
+			red, green, blue := parseColorValue(value)
+			rgba := gdk.NewRGBA(red, green, blue, 1)
+			colorButton := gtk.NewColorButtonWithRGBA(&rgba)
+			colorButton.Connect("color-set", func() {
+				selectedColor := colorButton.RGBA()
+				setPropertyOverride(wallpaperId, property.Name, formatColorValue(selectedColor.Red(), selectedColor.Green(), selectedColor.Blue()))
+			})
+			propertyWidget = colorButton
+		case "combo":
+			labels := []string{}
+			values := []string{}
+			for _, option := range property.Options {
+				labels = append(labels, option.Label)
+				values = append(values, formatPropertyValue(option.Value))
+			}
+			if len(values) == 0 {
+				continue
+			}
+			dropdown := gtk.NewDropDown(gtk.NewStringList(labels), nil)
+			dropdown.SetSelected(uint(max(slices.Index(values, value), 0)))
+			dropdown.Connect("notify::selected", func() {
+				setPropertyOverride(wallpaperId, property.Name, values[dropdown.Selected()])
+			})
+			propertyWidget = dropdown
+		case "textinput":
+			entry := gtk.NewEntry()
+			entry.SetText(value)
+			entry.Connect("changed", func() {
+				setPropertyOverride(wallpaperId, property.Name, entry.Text())
+			})
+			propertyWidget = entry
+		}
+
+		nameLabel := gtk.NewLabel(propertyLabel(property))
+		nameLabel.SetHAlign(gtk.AlignStart)
+		nameLabel.SetTooltipText(property.Name)
+		propertiesGrid.Attach(nameLabel, 0, row, 1, 1)
+		propertiesGrid.Attach(propertyWidget, 1, row, 1, 1)
+		row++
+	}
+
+	if row == 0 {
+		return nil
+	}
+
+	resetButton := gtk.NewButtonWithLabel("Reset Properties")
+	resetButton.SetHAlign(gtk.AlignStart)
+	resetButton.SetTooltipText("Changes are used the next time this wallpaper is applied")
+	resetButton.Connect("clicked", func() {
+		setPropertyOverride(wallpaperId, "", "")
+		showDetails(wallpaperItem)
+	})
+	propertiesGrid.Attach(resetButton, 0, row, 2, 1)
+
+	propertiesScrollable := gtk.NewScrolledWindow()
+	propertiesScrollable.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	propertiesScrollable.SetMinContentHeight(120)
+	propertiesScrollable.SetHExpand(true)
+	propertiesScrollable.SetChild(propertiesGrid)
+	return propertiesScrollable
+}
+
 // Saves a 128x128 preview image of the first path given, to the location of the second path.
 // Used to speed up the load times of the WallpaperItems
 func cacheImage(imagePath string, cachedThumbnailPath string, pixelSize int) {
@@ -453,8 +753,7 @@ func loadImageAsync(imagePath string, targetImage *gtk.Image, pixelSize int) {
 	go func() {
 		// check for cached thumbnail first
 		// TODO: add support for gifs
-		tempFilePath := path.Join(CacheDir, path.Base(path.Dir(imagePath)))
-		cachedThumbnailPath := path.Join(tempFilePath, "thumbnail.png") // ~/.cache/linux-wallpaperengine-helper/<wallpaper_id>/thumbnail.png
+		cachedThumbnailPath := thumbnailCachePath(path.Base(path.Dir(imagePath))) // ~/.cache/linux-wallpaperengine-helper/<wallpaper_id>/thumbnail.png
 
 		if _, err := os.Stat(cachedThumbnailPath); os.IsNotExist(err) {
 			log.Printf("Cached thumbnail not found for %s, creating it...", imagePath)
@@ -481,175 +780,6 @@ func loadImageAsync(imagePath string, targetImage *gtk.Image, pixelSize int) {
 	}()
 }
 
-// Helper function to sort the WallpaperItems by Modification Time
-func sortByModTime(descending bool) {
-	sort.SliceStable(WallpaperItems, func(i, j int) bool {
-		iModTime := WallpaperItems[i].ModTime
-		jModTime := WallpaperItems[j].ModTime
-		if iModTime.IsZero() || jModTime.IsZero() {
-			log.Printf("Error getting last modified info for wallpaper %s or %s", WallpaperItems[i].WallpaperID, WallpaperItems[j].WallpaperID)
-			return false // keep original order if there's an error
-		}
-		if descending {
-			return iModTime.After(jModTime)
-		} else {
-			return iModTime.Before(jModTime)
-		}
-	})
-}
-
-// Helper function to sort the WallpaperItems by the title in it's project.json
-//
-// Falls back to WallpaperID if Title is not provided.
-func sortByProjectTitle(descending bool) {
-	sort.SliceStable(WallpaperItems, func(i, j int) bool {
-		iName := WallpaperItems[i].projectJson.Title
-		jName := WallpaperItems[j].projectJson.Title
-		if iName == "" || jName == "" {
-			log.Printf("Error getting name info for wallpaper %s or %s", WallpaperItems[i].WallpaperID, WallpaperItems[j].WallpaperID)
-			return false // keep original order if there's an error
-		}
-		if descending {
-			return iName > jName
-		} else {
-			return iName < jName
-		}
-	})
-}
-
-// Helper function to sort all the items, respecting the config, favorites, and broken.
-//
-// First sorts all the Wallpapers by the SortBy selection from the Config.
-// Then it sorts them by putting all the Favorites first
-// Finally, it sorts them by putting all the Broken ones last.
-func sortWallpaperItems() {
-	// sort by date modified, newest first
-	switch Config.SavedUIState.SortBy {
-	case "date_desc":
-		sortByModTime(true)
-	case "date_asc":
-		sortByModTime(false)
-	case "name_desc":
-		sortByProjectTitle(true)
-	case "name_asc":
-		sortByProjectTitle(false)
-	default:
-		log.Printf("Unknown sort criteria: %s, defaulting to date_desc", Config.SavedUIState.SortBy)
-		sortByModTime(true)
-	}
-
-	// put favorites first
-	sort.SliceStable(WallpaperItems, func(i, j int) bool {
-		if WallpaperItems[i].IsFavorite && !WallpaperItems[j].IsFavorite {
-			return true
-		} else {
-			return false
-		}
-	})
-
-	// put broken wallpapers at the end
-	// this is important to do at the end, since a wallpaper can be a favorite and broken
-	// we want to make sure all the broken ones are at the bottom
-	sort.SliceStable(WallpaperItems, func(i, j int) bool {
-		if WallpaperItems[i].IsBroken && !WallpaperItems[j].IsBroken {
-			return false
-		} else if !WallpaperItems[i].IsBroken && WallpaperItems[j].IsBroken {
-			return true
-		} else {
-			return false
-		}
-	})
-}
-
-// Forces a full refresh of the WallpaperItems.
-//
-// This reads the WallpaperEngineDir (contents directory) to repopulate the WallpaperItems.
-//
-// First it reads the directory and its subdirectories (depth of 1).
-// Each subdirectory is considered a "wallpaper" and the name of the dir is its WallpaperID.
-//
-// Next it reads the project.json in the directory.
-// It parses the JSON for the wallpaper's Title, Description, and Tags.
-// If it fails reading the JSON, or it isn't present, the Title, Description, and Tags are all set to an empty string, "No description available", and empty string array respectively.
-//
-// Then it populates the rest of the WallpaperItem.
-// It adds the ID, cache location for the preview image, checks if its a favorite/broken, and adds the Modification Time.
-//
-// Finally, it adds the WallpaperItem to the global WallpaperItems slice.
-func reloadWallpaperData() error {
-	WallpaperItems = []WallpaperItem{}
-
-	wallpaperDir, err := ensureDir(Config.Constants.WallpaperEngineDir)
-	if err != nil {
-		return fmt.Errorf("failed to ensure wallpaper directory: %v", err)
-	}
-
-	wallpaperFolders, err := os.ReadDir(wallpaperDir)
-	if err != nil {
-		return fmt.Errorf("failed to read wallpaper directory: %v", err)
-	}
-
-	if len(wallpaperFolders) == 0 {
-		return errors.New("no wallpapers found in the wallpaper directory")
-	}
-
-	for _, wallpaperFolder := range wallpaperFolders {
-		if !wallpaperFolder.IsDir() {
-			log.Printf("Skipping non-directory entry: %s", wallpaperFolder.Name())
-			continue
-		}
-
-		wallpaperPath := path.Join(wallpaperDir, wallpaperFolder.Name())
-
-		projectJsonFilePath := path.Join(wallpaperPath, "project.json")
-		projectJson := ProjectJSON{}
-		data, err := os.ReadFile(projectJsonFilePath)
-		if err != nil {
-			log.Printf("Error reading project.json for wallpaper %s: %v", wallpaperFolder.Name(), err)
-			continue
-		}
-
-		err = json.Unmarshal(data, &projectJson)
-		if err != nil {
-			log.Printf("Error reading project.json for wallpaper %s: %v", wallpaperFolder.Name(), err)
-			projectJson = ProjectJSON{
-				Title:        wallpaperFolder.Name(),
-				Description:  "No description available",
-				Tags:         []string{},
-				PreviewImage: "",
-			}
-		}
-
-		var cachedImagePath string
-		if projectJson.PreviewImage == "" {
-			cachedImagePath = ""
-		} else {
-			cachedImagePath = path.Join(CacheDir, wallpaperFolder.Name(), projectJson.PreviewImage)
-		}
-
-		var modTime time.Time
-		info, err := wallpaperFolder.Info()
-		if err != nil {
-			log.Printf("Error getting info for wallpaper %s: %v", wallpaperFolder.Name(), err)
-			modTime = time.Time{} // default to zero value if we cannot get the mod time
-		} else {
-			modTime = info.ModTime()
-		}
-
-		WallpaperItems = append(WallpaperItems, WallpaperItem{
-			projectJson:   projectJson,
-			WallpaperID:   wallpaperFolder.Name(),
-			WallpaperPath: wallpaperPath,
-			CachedPath:    cachedImagePath,
-			IsFavorite:    slices.Contains(Config.SavedUIState.Favorites, wallpaperFolder.Name()),
-			IsBroken:      slices.Contains(Config.SavedUIState.Broken, wallpaperFolder.Name()),
-			ModTime:       modTime,
-		})
-	}
-
-	return nil
-}
-
 // Refreshes only the wallpaper display.
 // This reads the WallpaperItems currently set and updates the WallpaperList according to those.
 //
@@ -708,6 +838,11 @@ func refreshWallpaperDisplay() {
 				warningIcon := gtk.NewImageFromIconName("dialog-warning-symbolic")
 				warningIcon.SetPixelSize(24)
 				warningIcon.AddCSSClass("error")
+				if reason, ok := Config.SavedUIState.BrokenReasons[wallpaperItem.WallpaperID]; ok {
+					warningIcon.SetTooltipText("Broken: " + reason)
+				} else {
+					warningIcon.SetTooltipText("Marked as broken")
+				}
 				statusIcons.Append(warningIcon)
 			}
 
@@ -736,16 +871,7 @@ func filterWallpapersBySearch(query string) {
 		if query == "" {
 			return true // show all wallpapers if query is empty
 		}
-		titleMatch := strings.Contains(strings.ToLower(item.projectJson.Title), strings.ToLower(query))
-		descriptionMatch := strings.Contains(strings.ToLower(item.projectJson.Description), strings.ToLower(query))
-		tagsMatch := false
-		for _, tag := range item.projectJson.Tags {
-			if strings.Contains(strings.ToLower(tag), strings.ToLower(query)) {
-				tagsMatch = true
-				break
-			}
-		}
-		return titleMatch || descriptionMatch || tagsMatch
+		return matchesSearch(item, query)
 	})
 }
 
@@ -762,6 +888,8 @@ func filterWallpapers(predicate func(WallpaperItem) bool) {
 		if predicate(item) {
 			if Config.SavedUIState.HideBroken && item.IsBroken {
 				continue
+			} else if stats, ok := wallpaperStats(item.WallpaperID); ok && Config.SavedUIState.MaxAverageCPU > 0 && stats.AverageCPU > float64(Config.SavedUIState.MaxAverageCPU) {
+				continue
 			} else {
 				filtered = append(filtered, item)
 			}
@@ -802,7 +930,9 @@ func attachGestures(imageWidget *gtk.Overlay, wallpaperItem *WallpaperItem, isFa
 		log.Println("Applying wallpaper:", wallpaperItem.WallpaperID)
 		wallpaperDir := Config.Constants.WallpaperEngineDir
 		fullWallpaperPath := path.Join(wallpaperDir, wallpaperItem.WallpaperID)
-		go applyWallpaper(fullWallpaperPath, float64(Config.SavedUIState.Volume))
+		applyOrForward("wallpaper "+wallpaperItem.WallpaperID, "apply", ApplyParams{Wallpaper: fullWallpaperPath, Outputs: targetOutputs(), Volume: &Config.SavedUIState.Volume}, func(ctx context.Context) error {
+			return applyWallpaper(ctx, fullWallpaperPath, float64(Config.SavedUIState.Volume), targetOutputs()...)
+		})
 	})
 	actionGroup.AddAction(&applyAction.Action)
 
@@ -812,13 +942,15 @@ func attachGestures(imageWidget *gtk.Overlay, wallpaperItem *WallpaperItem, isFa
 		SelectedWallpaperItemId = wallpaperItem.WallpaperID
 		if isFavorite {
 			log.Printf("Removing %s from favorites", wallpaperItem.WallpaperID)
-			wallpaperItem.IsFavorite = false
-			Config.SavedUIState.Favorites = slices.Delete(Config.SavedUIState.Favorites, slices.Index(Config.SavedUIState.Favorites, wallpaperItem.WallpaperID), slices.Index(Config.SavedUIState.Favorites, wallpaperItem.WallpaperID)+1)
 		} else {
 			log.Printf("Favoriting %s", wallpaperItem.WallpaperID)
-			wallpaperItem.IsFavorite = true
-			Config.SavedUIState.Favorites = append(Config.SavedUIState.Favorites, wallpaperItem.WallpaperID)
 		}
+		if err := setFavorite(wallpaperItem.WallpaperID, !isFavorite); err != nil {
+			log.Printf("Error saving favorite %s: %v", wallpaperItem.WallpaperID, err)
+			updateGUIStatusText("Failed to save the favorite.")
+			return
+		}
+		wallpaperItem.IsFavorite = !isFavorite
 
 		for i := range WallpaperItems {
 			if WallpaperItems[i].WallpaperID == wallpaperItem.WallpaperID {
@@ -837,13 +969,15 @@ func attachGestures(imageWidget *gtk.Overlay, wallpaperItem *WallpaperItem, isFa
 		SelectedWallpaperItemId = wallpaperItem.WallpaperID
 		if isBroken {
 			log.Printf("Marking %s as not broken", wallpaperItem.WallpaperID)
-			wallpaperItem.IsBroken = false
-			Config.SavedUIState.Broken = slices.Delete(Config.SavedUIState.Broken, slices.Index(Config.SavedUIState.Broken, wallpaperItem.WallpaperID), slices.Index(Config.SavedUIState.Broken, wallpaperItem.WallpaperID)+1)
 		} else {
 			log.Printf("Marking %s as broken", wallpaperItem.WallpaperID)
-			wallpaperItem.IsBroken = true
-			Config.SavedUIState.Broken = append(Config.SavedUIState.Broken, wallpaperItem.WallpaperID)
 		}
+		if err := setBroken(wallpaperItem.WallpaperID, !isBroken, ""); err != nil {
+			log.Printf("Error saving broken mark of %s: %v", wallpaperItem.WallpaperID, err)
+			updateGUIStatusText("Failed to save the broken mark.")
+			return
+		}
+		wallpaperItem.IsBroken = !isBroken
 
 		for i := range WallpaperItems {
 			if WallpaperItems[i].WallpaperID == wallpaperItem.WallpaperID {
@@ -875,13 +1009,37 @@ func attachGestures(imageWidget *gtk.Overlay, wallpaperItem *WallpaperItem, isFa
 	})
 	actionGroup.AddAction(&openDirectoryAction.Action)
 
+	// view_engine_log action
+	viewEngineLogAction := gio.NewSimpleAction("view_engine_log", nil)
+	viewEngineLogAction.Connect("activate", func(_ *gio.SimpleAction, _ any) {
+		logPath, err := latestEngineLog(wallpaperItem.WallpaperID)
+		if err != nil {
+			log.Printf("Error finding engine log: %v", err)
+			updateGUIStatusText("No engine log found for this wallpaper, apply it first.")
+			return
+		}
+		_, err = runDetachedProcess("xdg-open", logPath)
+		if err != nil {
+			log.Printf("Error opening engine log %s: %v", logPath, err)
+			return
+		}
+		log.Printf("Opened engine log for wallpaper %s: %s", wallpaperItem, logPath)
+	})
+	actionGroup.AddAction(&viewEngineLogAction.Action)
+
 	// copy_command action
 	copyCommandAction := gio.NewSimpleAction("copy_command", nil)
 	copyCommandAction.Connect("activate", func(_ *gio.SimpleAction, _ any) {
 		log.Printf("Copying command for %s to clipboard", wallpaperItem)
 		wallpaperDir := Config.Constants.WallpaperEngineDir
 		fullWallpaperPath := path.Join(wallpaperDir, wallpaperItem.WallpaperID)
-		cmd, _ := createWallpaperCommand(fullWallpaperPath, float64(Config.SavedUIState.Volume))
+		// one command per output, as every output runs its own process
+		commands := []string{}
+		for _, output := range targetOutputs() {
+			command, _ := createWallpaperCommand(output, fullWallpaperPath, float64(Config.SavedUIState.Volume), "", false)
+			commands = append(commands, formatCommand(command))
+		}
+		cmd := strings.Join(commands, "\n")
 		clipboard := gdk.DisplayGetDefault().Clipboard()
 		// if clipboard == nil {
 		// 	log.Println("Error getting clipboard")
@@ -892,6 +1050,53 @@ func attachGestures(imageWidget *gtk.Overlay, wallpaperItem *WallpaperItem, isFa
 	})
 	actionGroup.AddAction(&copyCommandAction.Action)
 
+	// per-wallpaper settings actions, the target is the value to set, or an empty string to use the default
+	wallpaperSettings := Config.Wallpapers[wallpaperItem.WallpaperID]
+	addWallpaperSettingAction(actionGroup, wallpaperItem.WallpaperID, "scaling", wallpaperSettings.Scaling, func(settings *WallpaperSettingsStruct, value string) {
+		settings.Scaling = value
+	})
+	addWallpaperSettingAction(actionGroup, wallpaperItem.WallpaperID, "clamping", wallpaperSettings.Clamping, func(settings *WallpaperSettingsStruct, value string) {
+		settings.Clamping = value
+	})
+	fpsState := ""
+	if wallpaperSettings.FPS != nil {
+		fpsState = strconv.FormatInt(*wallpaperSettings.FPS, 10)
+	}
+	addWallpaperSettingAction(actionGroup, wallpaperItem.WallpaperID, "fps", fpsState, func(settings *WallpaperSettingsStruct, value string) {
+		settings.FPS = nil
+		if fps, err := strconv.ParseInt(value, 10, 64); err == nil {
+			settings.FPS = &fps
+		}
+	})
+	addWallpaperSettingAction(actionGroup, wallpaperItem.WallpaperID, "no_fullscreen_pause", boolPointerState(wallpaperSettings.NoFullscreenPause), func(settings *WallpaperSettingsStruct, value string) {
+		settings.NoFullscreenPause = parseBoolPointerState(value)
+	})
+	addWallpaperSettingAction(actionGroup, wallpaperItem.WallpaperID, "disable_mouse", boolPointerState(wallpaperSettings.DisableMouse), func(settings *WallpaperSettingsStruct, value string) {
+		settings.DisableMouse = parseBoolPointerState(value)
+	})
+	addWallpaperSettingAction(actionGroup, wallpaperItem.WallpaperID, "no_automute", boolPointerState(wallpaperSettings.NoAutomute), func(settings *WallpaperSettingsStruct, value string) {
+		settings.NoAutomute = parseBoolPointerState(value)
+	})
+	addWallpaperSettingAction(actionGroup, wallpaperItem.WallpaperID, "no_audio_processing", boolPointerState(wallpaperSettings.NoAudioProcessing), func(settings *WallpaperSettingsStruct, value string) {
+		settings.NoAudioProcessing = parseBoolPointerState(value)
+	})
+
+	// reset_engine_overrides action
+	resetEngineOverridesAction := gio.NewSimpleAction("reset_engine_overrides", nil)
+	resetEngineOverridesAction.Connect("activate", func(_ *gio.SimpleAction, _ any) {
+		log.Printf("Resetting engine overrides of %s", wallpaperItem.WallpaperID)
+		updateWallpaperSettings(wallpaperItem.WallpaperID, func(settings *WallpaperSettingsStruct) {
+			settings.FPS = nil
+			settings.NoFullscreenPause = nil
+			settings.DisableMouse = nil
+			settings.NoAutomute = nil
+			settings.NoAudioProcessing = nil
+		})
+		// the actions' states are outdated now, so recreate them
+		refreshWallpaperDisplay()
+	})
+	actionGroup.AddAction(&resetEngineOverridesAction.Action)
+
 	imageWidget.InsertActionGroup(wallpaperItem.WallpaperID, actionGroup)
 
 	rightClickGesture := gtk.NewGestureClick()
@@ -914,8 +1119,29 @@ func attachGestures(imageWidget *gtk.Overlay, wallpaperItem *WallpaperItem, isFa
 				contextMenuModel.Append("Mark as Broken", wallpaperItem.WallpaperID+".toggle_broken")
 			}
 			contextMenuModel.Append("Open Wallpaper Directory", wallpaperItem.WallpaperID+".open_directory")
+			contextMenuModel.Append("View Engine Log", wallpaperItem.WallpaperID+".view_engine_log")
 			contextMenuModel.Append("Copy Command to Clipboard", wallpaperItem.WallpaperID+".copy_command")
 
+			contextMenuModel.AppendSubmenu("Scaling", newWallpaperSettingSubmenu(wallpaperItem.WallpaperID, "scaling", Config.SavedUIState.Scaling, ScalingModes, ScalingModes))
+			contextMenuModel.AppendSubmenu("Clamping", newWallpaperSettingSubmenu(wallpaperItem.WallpaperID, "clamping", Config.SavedUIState.Clamping, ClampingModes, ClampingModes))
+
+			engineMenuModel := gio.NewMenu()
+			defaultFPS := "No Limit"
+			if Config.Engine.FPS > 0 {
+				defaultFPS = strconv.FormatInt(Config.Engine.FPS, 10) + " FPS"
+			}
+			fpsLabels := []string{}
+			for _, fps := range FPSChoices {
+				fpsLabels = append(fpsLabels, fps+" FPS")
+			}
+			engineMenuModel.AppendSubmenu("Frame Rate Limit", newWallpaperSettingSubmenu(wallpaperItem.WallpaperID, "fps", defaultFPS, FPSChoices, fpsLabels))
+			engineMenuModel.AppendSubmenu("Keep Rendering While Fullscreen", newWallpaperSettingSubmenu(wallpaperItem.WallpaperID, "no_fullscreen_pause", boolLabel(Config.Engine.NoFullscreenPause), []string{"true", "false"}, []string{"On", "Off"}))
+			engineMenuModel.AppendSubmenu("Disable Mouse", newWallpaperSettingSubmenu(wallpaperItem.WallpaperID, "disable_mouse", boolLabel(Config.Engine.DisableMouse), []string{"true", "false"}, []string{"On", "Off"}))
+			engineMenuModel.AppendSubmenu("Keep Audio While Others Play", newWallpaperSettingSubmenu(wallpaperItem.WallpaperID, "no_automute", boolLabel(Config.Engine.NoAutomute), []string{"true", "false"}, []string{"On", "Off"}))
+			engineMenuModel.AppendSubmenu("Disable Audio Processing", newWallpaperSettingSubmenu(wallpaperItem.WallpaperID, "no_audio_processing", boolLabel(Config.Engine.NoAudioProcessing), []string{"true", "false"}, []string{"On", "Off"}))
+			engineMenuModel.Append("Reset Engine Overrides", wallpaperItem.WallpaperID+".reset_engine_overrides")
+			contextMenuModel.AppendSubmenu("Engine Overrides", engineMenuModel)
+
 			contextMenu := gtk.NewPopoverMenuFromModel(contextMenuModel)
 			contextMenu.SetParent(imageWidget)
 
@@ -940,10 +1166,68 @@ func attachGestures(imageWidget *gtk.Overlay, wallpaperItem *WallpaperItem, isFa
 			log.Println("Double-click detected, applying wallpaper:", wallpaperItem.WallpaperID)
 			wallpaperDir := Config.Constants.WallpaperEngineDir
 			fullWallpaperPath := path.Join(wallpaperDir, wallpaperItem.WallpaperID)
-			go applyWallpaper(fullWallpaperPath, float64(Config.SavedUIState.Volume))
+			applyOrForward("wallpaper "+wallpaperItem.WallpaperID, "apply", ApplyParams{Wallpaper: fullWallpaperPath, Outputs: targetOutputs(), Volume: &Config.SavedUIState.Volume}, func(ctx context.Context) error {
+				return applyWallpaper(ctx, fullWallpaperPath, float64(Config.SavedUIState.Volume), targetOutputs()...)
+			})
 		}
 	})
 	imageWidget.AddController(leftClickGesture)
 
 	log.Println("Context menu attached to image widget for wallpaper:", wallpaperItem.WallpaperID)
 }
+
+// The frame rate limits that can be picked from the "Engine Overrides" menu of a wallpaper.
+var FPSChoices = []string{"10", "15", "24", "30", "60", "120", "144"}
+
+// Helper function to add a stateful action for a per-wallpaper setting to the action group, see newWallpaperSettingSubmenu.
+//
+// The action takes a string target, which is passed to update together with the wallpaper's settings in Config.Wallpapers.
+// An empty target means the setting should be unset, so the default is used.
+func addWallpaperSettingAction(actionGroup *gio.SimpleActionGroup, wallpaperId string, name string, state string, update func(settings *WallpaperSettingsStruct, value string)) {
+	action := gio.NewSimpleActionStateful(name, glibv2.NewVariantType("s"), glibv2.NewVariantString(state))
+	action.ConnectActivate(func(parameter *glibv2.Variant) {
+		log.Printf("Setting %s of %s to '%s'", name, wallpaperId, parameter.String())
+		updateWallpaperSettings(wallpaperId, func(settings *WallpaperSettingsStruct) {
+			update(settings, parameter.String())
+		})
+		action.SetState(parameter)
+	})
+	actionGroup.AddAction(&action.Action)
+}
+
+// Helper function to create a submenu of radio items for an action created by addWallpaperSettingAction.
+//
+// The first item unsets the setting, and shows the default value. Every other item sets the setting to the value at the same index as its label.
+func newWallpaperSettingSubmenu(wallpaperId string, name string, defaultValue string, values []string, labels []string) *gio.Menu {
+	menu := gio.NewMenu()
+	menu.AppendItem(gio.NewMenuItem("Use Default ("+defaultValue+")", wallpaperId+"."+name+"::"))
+	for i, value := range values {
+		menu.AppendItem(gio.NewMenuItem(labels[i], wallpaperId+"."+name+"::"+value))
+	}
+	return menu
+}
+
+// Helper function to convert an optional bool override to an action state; an empty string if unset.
+func boolPointerState(value *bool) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*value)
+}
+
+// Helper function to convert an action state back to an optional bool override; nil if the state is not a bool.
+func parseBoolPointerState(state string) *bool {
+	value, err := strconv.ParseBool(state)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+// Helper function to show a bool setting as "On" or "Off".
+func boolLabel(value bool) string {
+	if value {
+		return "On"
+	}
+	return "Off"
+}
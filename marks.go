@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/urfave/cli/v3"
+)
+
+// Returns the list with the value added if member is true, or removed otherwise. The value is never added twice.
+func setListMember(list []string, value string, member bool) []string {
+	list = slices.DeleteFunc(slices.Clone(list), func(item string) bool { return item == value })
+	if member {
+		list = append(list, value)
+	}
+	return list
+}
+
+// Adds the wallpaper to Config.SavedUIState.Favorites, or removes it.
+//
+// The change is written to the config file right away with updateConfigFile, like every change to the favorites and broken marks,
+// so the GUI, the daemon, and the CLI do not overwrite each other's marks, see mergeConfigFileMarks.
+func setFavorite(wallpaperId string, favorite bool) error {
+	return updateConfigFile(func(config *ConfigStruct) {
+		config.SavedUIState.Favorites = setListMember(config.SavedUIState.Favorites, wallpaperId, favorite)
+	})
+}
+
+// Adds the wallpaper to Config.SavedUIState.Broken, or removes it, and writes the change to the config file, see setFavorite.
+//
+// When marking it as broken, a non-empty reason is saved in Config.SavedUIState.BrokenReasons; when unmarking it, its reason is removed.
+func setBroken(wallpaperId string, broken bool, reason string) error {
+	return updateConfigFile(func(config *ConfigStruct) {
+		config.SavedUIState.Broken = setListMember(config.SavedUIState.Broken, wallpaperId, broken)
+		if config.SavedUIState.BrokenReasons == nil {
+			config.SavedUIState.BrokenReasons = map[string]string{}
+		}
+		if !broken {
+			delete(config.SavedUIState.BrokenReasons, wallpaperId)
+		} else if reason != "" {
+			config.SavedUIState.BrokenReasons[wallpaperId] = reason
+		}
+	})
+}
+
+// Removes every wallpaper from Config.SavedUIState.Favorites, and writes the change to the config file, see setFavorite.
+func resetFavorites() error {
+	return updateConfigFile(func(config *ConfigStruct) {
+		config.SavedUIState.Favorites = []string{}
+	})
+}
+
+// Removes every wallpaper from Config.SavedUIState.Broken, and writes the change to the config file, see setFavorite.
+func resetBroken() error {
+	return updateConfigFile(func(config *ConfigStruct) {
+		config.SavedUIState.Broken = []string{}
+		config.SavedUIState.BrokenReasons = map[string]string{}
+	})
+}
+
+// Takes the favorites and broken marks from the config file into Config, and merges the last applied times.
+//
+// The marks are always written to the file right away (see setFavorite), so the file has the latest ones,
+// also when another process changed them. Expects the config lock to be held, see withConfigLock.
+func mergeConfigFileMarks(configFile string) error {
+	config, err := readConfigFile(configFile)
+	if err != nil || config == nil {
+		return err
+	}
+	mergeMarks(config)
+	return nil
+}
+
+// Reads and parses the config file, without validating it. Returns nil if the file does not exist.
+func readConfigFile(configFile string) (*ConfigStruct, error) {
+	content, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	config := NewDefaultConfig("")
+	if err := toml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config file: %v", err)
+	}
+	return config, nil
+}
+
+// Takes the favorites and broken marks of the given config (read from the config file) into Config, and merges the last applied times.
+func mergeMarks(config *ConfigStruct) {
+	Config.SavedUIState.Favorites = config.SavedUIState.Favorites
+	Config.SavedUIState.Broken = config.SavedUIState.Broken
+	Config.SavedUIState.BrokenReasons = config.SavedUIState.BrokenReasons
+	if Config.SavedUIState.LastApplied == nil {
+		Config.SavedUIState.LastApplied = map[string]time.Time{}
+	}
+	for id, appliedAt := range config.SavedUIState.LastApplied {
+		if appliedAt.After(Config.SavedUIState.LastApplied[id]) {
+			Config.SavedUIState.LastApplied[id] = appliedAt
+		}
+	}
+}
+
+// Updates IsFavorite and IsBroken of the WallpaperItems from Config, and refreshes the GUI if any of them changed.
+func refreshWallpaperMarks() {
+	changed := false
+	for i := range WallpaperItems {
+		isFavorite := slices.Contains(Config.SavedUIState.Favorites, WallpaperItems[i].WallpaperID)
+		isBroken := slices.Contains(Config.SavedUIState.Broken, WallpaperItems[i].WallpaperID)
+		if WallpaperItems[i].IsFavorite != isFavorite || WallpaperItems[i].IsBroken != isBroken {
+			WallpaperItems[i].IsFavorite = isFavorite
+			WallpaperItems[i].IsBroken = isBroken
+			changed = true
+		}
+	}
+	if changed {
+		refreshGUIWallpaperDisplay()
+	}
+}
+
+// Polls the modification time of the config file every interval until ctx is cancelled,
+// and takes the favorites and broken marks changed by another process, e.g. the CLI, see mergeMarks.
+// Only reading the file holds the config lock; the marks are updated with runStateUpdate.
+//
+// Meant to be run as a goroutine.
+func watchConfigFile(ctx context.Context, interval time.Duration) {
+	lastModTime := time.Time{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var fileConfig *ConfigStruct
+		err := withConfigLock(func(configFile string) error {
+			info, err := os.Stat(configFile)
+			if err != nil {
+				return err
+			}
+			if lastModTime.IsZero() || info.ModTime().Equal(lastModTime) {
+				lastModTime = info.ModTime()
+				return nil
+			}
+			lastModTime = info.ModTime()
+
+			fileConfig, err = readConfigFile(configFile)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to check the config file for changes: %v", err)
+			continue
+		}
+		if fileConfig == nil {
+			continue
+		}
+
+		log.Printf("Config file changed, updating favorites and broken marks")
+		runStateUpdate(func() {
+			mergeMarks(fileConfig)
+			refreshWallpaperMarks()
+		})
+	}
+}
+
+// Returns the wallpaper IDs for the arguments of the `favorite` and `broken` commands.
+//
+// An argument that is in the list (e.g. the favorites) is used as is, so wallpapers that were deleted can still be removed.
+// Other arguments are looked up with findWallpapers, and must match exactly one wallpaper.
+func resolveWallpaperIds(args []string, list []string) ([]string, error) {
+	ids := []string{}
+	for _, arg := range args {
+		if slices.Contains(list, arg) {
+			ids = append(ids, arg)
+			continue
+		}
+
+		if len(WallpaperItems) == 0 {
+			if err := reloadWallpaperData(); err != nil {
+				return nil, err
+			}
+		}
+		matches := findWallpapers(arg)
+		if len(matches) > 1 {
+			printWallpaperCandidates(os.Stdout, arg, matches)
+			return nil, fmt.Errorf("%d wallpapers match %q", len(matches), arg)
+		} else if len(matches) == 0 {
+			return nil, fmt.Errorf("no wallpaper matches %q", arg)
+		}
+		ids = append(ids, matches[0].WallpaperID)
+	}
+	return ids, nil
+}
+
+// Prints the wallpapers in the library whose IDs are in the list, see printWallpaperList.
+// Also prints the IDs in the list that are not in the library anymore, unless the format is for scripts.
+func printMarkedWallpapers(list []string, format string) error {
+	items, err := scanWallpapers()
+	if err != nil {
+		return err
+	}
+	marked := slices.DeleteFunc(items, func(item WallpaperItem) bool { return !slices.Contains(list, item.WallpaperID) })
+	sortWallpapers(marked, Config.SavedUIState.SortBy)
+	if err := printWallpaperList(os.Stdout, marked, format); err != nil {
+		return err
+	}
+
+	if format == "table" {
+		found := map[string]bool{}
+		for _, item := range marked {
+			found[item.WallpaperID] = true
+		}
+		missing := slices.DeleteFunc(slices.Clone(list), func(id string) bool { return found[id] })
+		if len(missing) > 0 {
+			fmt.Printf("\nNot in the wallpaper directory anymore: %v\n", missing)
+		}
+	}
+	return nil
+}
+
+// Runs set for the wallpaper of every argument of the `favorite` and `broken` commands, see resolveWallpaperIds.
+func setMarksFromCLI(args []string, list []string, set func(id string) error) error {
+	if len(args) == 0 {
+		return cli.Exit("Missing the wallpapers, e.g. 1234567890.", 1)
+	}
+
+	ids, err := resolveWallpaperIds(args, list)
+	if err != nil {
+		log.Printf("Error finding the wallpapers: %v", err)
+		return cli.Exit(fmt.Sprintf("Failed to find the wallpapers: %v", err), 1)
+	}
+	for _, id := range ids {
+		if err := set(id); err != nil {
+			log.Printf("Error saving wallpaper %s: %v", id, err)
+			return cli.Exit("Failed to save the wallpapers.", 1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// The sysfs directory containing the DRM connectors, used as a fallback when no display tool is available.
+var DRMSysfsRoot string = "/sys/class/drm"
+
+type MonitorInfo struct {
+	Name    string  `json:"name"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	Refresh float64 `json:"refresh"`
+	Source  string  `json:"source"`
+}
+
+// A way of listing the connected monitors.
+//
+// If available returns true, command is run and its stdout is passed to parse.
+type monitorDetector struct {
+	source    string
+	command   []string
+	available func() bool
+	parse     func(output []byte) ([]MonitorInfo, error)
+}
+
+var monitorDetectors = []monitorDetector{
+	{
+		source:    "hyprctl",
+		command:   []string{"hyprctl", "monitors", "-j"},
+		available: func() bool { return os.Getenv("HYPRLAND_INSTANCE_SIGNATURE") != "" },
+		parse:     parseHyprctlMonitors,
+	},
+	{
+		source:    "swaymsg",
+		command:   []string{"swaymsg", "-t", "get_outputs", "-r"},
+		available: func() bool { return os.Getenv("SWAYSOCK") != "" },
+		parse:     parseSwaymsgOutputs,
+	},
+	{
+		source:    "wlr-randr",
+		command:   []string{"wlr-randr"},
+		available: func() bool { return os.Getenv("WAYLAND_DISPLAY") != "" },
+		parse:     parseWlrRandr,
+	},
+	{
+		source:    "xrandr",
+		command:   []string{"xrandr", "--query"},
+		available: func() bool { return os.Getenv("DISPLAY") != "" },
+		parse:     parseXrandrQuery,
+	},
+}
+
+// Returns the connected (and enabled) monitors.
+//
+// Tries every available detector in monitorDetectors in order, and returns the first non-empty result.
+// If none of them work, it falls back to reading the connector status from DRMSysfsRoot.
+func listMonitors() ([]MonitorInfo, error) {
+	for _, detector := range monitorDetectors {
+		if !detector.available() {
+			continue
+		}
+
+		output, err := exec.Command(detector.command[0], detector.command[1:]...).Output()
+		if err != nil {
+			log.Printf("Failed to list monitors using %s: %v", detector.source, err)
+			continue
+		}
+
+		monitors, err := detector.parse(output)
+		if err != nil {
+			log.Printf("Failed to parse %s output: %v", detector.source, err)
+			continue
+		}
+		if len(monitors) == 0 {
+			log.Printf("%s did not report any monitors", detector.source)
+			continue
+		}
+
+		for i := range monitors {
+			monitors[i].Source = detector.source
+		}
+		return monitors, nil
+	}
+
+	log.Println("Falling back to DRM connector status to list monitors")
+	return readDRMMonitors(DRMSysfsRoot)
+}
+
+// Parses the output of `hyprctl monitors -j`.
+func parseHyprctlMonitors(output []byte) ([]MonitorInfo, error) {
+	var hyprMonitors []struct {
+		Name        string  `json:"name"`
+		Width       int     `json:"width"`
+		Height      int     `json:"height"`
+		RefreshRate float64 `json:"refreshRate"`
+		X           int     `json:"x"`
+		Y           int     `json:"y"`
+		Disabled    bool    `json:"disabled"`
+	}
+	if err := json.Unmarshal(output, &hyprMonitors); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal hyprctl output: %v", err)
+	}
+
+	monitors := []MonitorInfo{}
+	for _, monitor := range hyprMonitors {
+		if monitor.Disabled {
+			continue
+		}
+		monitors = append(monitors, MonitorInfo{
+			Name:    monitor.Name,
+			Width:   monitor.Width,
+			Height:  monitor.Height,
+			X:       monitor.X,
+			Y:       monitor.Y,
+			Refresh: monitor.RefreshRate,
+		})
+	}
+	return monitors, nil
+}
+
+// Parses the output of `swaymsg -t get_outputs -r`.
+//
+// sway reports the refresh rate in mHz, so it is converted to Hz.
+func parseSwaymsgOutputs(output []byte) ([]MonitorInfo, error) {
+	var swayOutputs []struct {
+		Name   string `json:"name"`
+		Active bool   `json:"active"`
+		Rect   struct {
+			X int `json:"x"`
+			Y int `json:"y"`
+		} `json:"rect"`
+		CurrentMode struct {
+			Width   int `json:"width"`
+			Height  int `json:"height"`
+			Refresh int `json:"refresh"`
+		} `json:"current_mode"`
+	}
+	if err := json.Unmarshal(output, &swayOutputs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal swaymsg output: %v", err)
+	}
+
+	monitors := []MonitorInfo{}
+	for _, output := range swayOutputs {
+		if !output.Active {
+			continue
+		}
+		monitors = append(monitors, MonitorInfo{
+			Name:    output.Name,
+			Width:   output.CurrentMode.Width,
+			Height:  output.CurrentMode.Height,
+			X:       output.Rect.X,
+			Y:       output.Rect.Y,
+			Refresh: float64(output.CurrentMode.Refresh) / 1000,
+		})
+	}
+	return monitors, nil
+}
+
+var wlrRandrModeRegex = regexp.MustCompile(`^(\d+)x(\d+) px, ([\d.]+) Hz.*\bcurrent\b`)
+var wlrRandrPositionRegex = regexp.MustCompile(`^Position: (-?\d+),(-?\d+)`)
+
+// Parses the output of `wlr-randr`.
+//
+// Every output starts with an unindented line with its name, followed by indented properties and modes.
+// Outputs with "Enabled: no" are skipped.
+func parseWlrRandr(output []byte) ([]MonitorInfo, error) {
+	monitors := []MonitorInfo{}
+	var current *MonitorInfo
+	enabled := true
+
+	flush := func() {
+		if current != nil && enabled {
+			monitors = append(monitors, *current)
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			flush()
+			current = &MonitorInfo{Name: strings.Fields(line)[0]}
+			enabled = true
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "Enabled: no" {
+			enabled = false
+		} else if match := wlrRandrModeRegex.FindStringSubmatch(line); match != nil {
+			current.Width, _ = strconv.Atoi(match[1])
+			current.Height, _ = strconv.Atoi(match[2])
+			current.Refresh, _ = strconv.ParseFloat(match[3], 64)
+		} else if match := wlrRandrPositionRegex.FindStringSubmatch(line); match != nil {
+			current.X, _ = strconv.Atoi(match[1])
+			current.Y, _ = strconv.Atoi(match[2])
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read wlr-randr output: %v", err)
+	}
+	return monitors, nil
+}
+
+var xrandrOutputRegex = regexp.MustCompile(`^(\S+) connected (?:primary )?(\d+)x(\d+)\+(-?\d+)\+(-?\d+)`)
+var xrandrCurrentRateRegex = regexp.MustCompile(`([\d.]+)\*`)
+
+// Parses the output of `xrandr --query`.
+//
+// Only connected outputs with a geometry (i.e. enabled) are returned.
+// The refresh rate is read from the mode marked with a "*" under the output.
+func parseXrandrQuery(output []byte) ([]MonitorInfo, error) {
+	monitors := []MonitorInfo{}
+	var current *MonitorInfo
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !strings.HasPrefix(line, " ") {
+			current = nil
+			if match := xrandrOutputRegex.FindStringSubmatch(line); match != nil {
+				monitor := MonitorInfo{Name: match[1]}
+				monitor.Width, _ = strconv.Atoi(match[2])
+				monitor.Height, _ = strconv.Atoi(match[3])
+				monitor.X, _ = strconv.Atoi(match[4])
+				monitor.Y, _ = strconv.Atoi(match[5])
+				monitors = append(monitors, monitor)
+				current = &monitors[len(monitors)-1]
+			}
+			continue
+		}
+
+		if current != nil && current.Refresh == 0 {
+			if match := xrandrCurrentRateRegex.FindStringSubmatch(line); match != nil {
+				current.Refresh, _ = strconv.ParseFloat(match[1], 64)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read xrandr output: %v", err)
+	}
+	return monitors, nil
+}
+
+// Reads the connected monitors from the DRM connectors in the given sysfs directory (usually /sys/class/drm).
+//
+// Each connector is a directory named card<N>-<output>, e.g. card0-HDMI-A-1, with a "status" file.
+// The resolution is read from the first (preferred) entry of the "modes" file, as the current mode and position are not exposed.
+func readDRMMonitors(root string) ([]MonitorInfo, error) {
+	statusFiles, err := filepath.Glob(filepath.Join(root, "card*-*", "status"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DRM connectors: %v", err)
+	}
+
+	monitors := []MonitorInfo{}
+	for _, statusFile := range statusFiles {
+		status, err := os.ReadFile(statusFile)
+		if err != nil {
+			log.Printf("Failed to read DRM connector status %s: %v", statusFile, err)
+			continue
+		}
+		if strings.TrimSpace(string(status)) != "connected" {
+			continue
+		}
+
+		connectorDir := filepath.Dir(statusFile)
+		_, name, _ := strings.Cut(filepath.Base(connectorDir), "-")
+		monitor := MonitorInfo{Name: name, Source: "drm"}
+
+		if modes, err := os.ReadFile(filepath.Join(connectorDir, "modes")); err == nil {
+			preferredMode, _, _ := strings.Cut(string(modes), "\n")
+			width, height, _ := strings.Cut(preferredMode, "x")
+			monitor.Width, _ = strconv.Atoi(width)
+			monitor.Height, _ = strconv.Atoi(strings.TrimRightFunc(height, func(r rune) bool { return r < '0' || r > '9' }))
+		}
+
+		monitors = append(monitors, monitor)
+	}
+	return monitors, nil
+}
+
+// Returns a short human readable description of the monitor, e.g. "1920x1080 @ 60.00Hz at 0,0".
+func describeMonitor(monitor MonitorInfo) string {
+	description := "unknown resolution"
+	if monitor.Width > 0 && monitor.Height > 0 {
+		description = fmt.Sprintf("%dx%d", monitor.Width, monitor.Height)
+	}
+	if monitor.Refresh > 0 {
+		description += fmt.Sprintf(" @ %.2fHz", monitor.Refresh)
+	}
+	if monitor.Source != "drm" {
+		description += fmt.Sprintf(" at %d,%d", monitor.X, monitor.Y)
+	}
+	return description
+}
+
+// Prints the connected monitors to the given writer, as a table or as JSON.
+//
+// The table also shows whether each monitor is one of the configured outputs in Config.Constants.Outputs.
+func printMonitors(writer io.Writer, asJSON bool) error {
+	monitors, err := listMonitors()
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(monitors)
+	}
+
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tRESOLUTION\tPOSITION\tREFRESH\tSOURCE\tCONFIGURED")
+	for _, monitor := range monitors {
+		position := "-"
+		if monitor.Source != "drm" {
+			position = fmt.Sprintf("%d,%d", monitor.X, monitor.Y)
+		}
+		refresh := "-"
+		if monitor.Refresh > 0 {
+			refresh = fmt.Sprintf("%.2fHz", monitor.Refresh)
+		}
+		configured := "no"
+		if slices.Contains(Config.Constants.Outputs, monitor.Name) {
+			configured = "yes"
+		}
+		fmt.Fprintf(table, "%s\t%dx%d\t%s\t%s\t%s\t%s\n", monitor.Name, monitor.Width, monitor.Height, position, refresh, monitor.Source, configured)
+	}
+	return table.Flush()
+}
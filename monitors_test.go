@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMonitorParsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		parse   func(output []byte) ([]MonitorInfo, error)
+		want    []MonitorInfo
+	}{
+		{
+			name:    "hyprctl",
+			fixture: "hyprctl.json",
+			parse:   parseHyprctlMonitors,
+			want: []MonitorInfo{
+				{Name: "DP-1", Width: 3840, Height: 2160, X: 0, Y: 0, Refresh: 59.997},
+				{Name: "HDMI-A-1", Width: 1920, Height: 1080, X: 2560, Y: -120, Refresh: 60},
+			},
+		},
+		{
+			name:    "swaymsg",
+			fixture: "swaymsg.json",
+			parse:   parseSwaymsgOutputs,
+			want: []MonitorInfo{
+				{Name: "DP-1", Width: 2560, Height: 1440, X: 0, Y: 0, Refresh: 143.912},
+				{Name: "HDMI-A-1", Width: 1920, Height: 1080, X: 2560, Y: 180, Refresh: 60},
+			},
+		},
+		{
+			name:    "wlr-randr",
+			fixture: "wlr-randr.txt",
+			parse:   parseWlrRandr,
+			want: []MonitorInfo{
+				{Name: "DP-1", Width: 3840, Height: 2160, X: 0, Y: 0, Refresh: 59.997002},
+				{Name: "HDMI-A-1", Width: 1920, Height: 1080, X: 2560, Y: -120, Refresh: 60},
+			},
+		},
+		{
+			name:    "xrandr",
+			fixture: "xrandr.txt",
+			parse:   parseXrandrQuery,
+			want: []MonitorInfo{
+				{Name: "DP-1", Width: 2560, Height: 1440, X: 0, Y: 0, Refresh: 143.91},
+				{Name: "HDMI-1", Width: 1920, Height: 1080, X: 2560, Y: 180, Refresh: 60},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", "monitors", test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			monitors, err := test.parse(output)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			if !reflect.DeepEqual(monitors, test.want) {
+				t.Errorf("got %+v, want %+v", monitors, test.want)
+			}
+		})
+	}
+}
+
+func TestMonitorParsersInvalidOutput(t *testing.T) {
+	if _, err := parseHyprctlMonitors([]byte("Couldn't connect to hyprland")); err == nil {
+		t.Error("parseHyprctlMonitors: expected an error for non-JSON output")
+	}
+	if _, err := parseSwaymsgOutputs([]byte("Unable to retrieve socket path")); err == nil {
+		t.Error("parseSwaymsgOutputs: expected an error for non-JSON output")
+	}
+
+	for name, parse := range map[string]func([]byte) ([]MonitorInfo, error){"wlr-randr": parseWlrRandr, "xrandr": parseXrandrQuery} {
+		monitors, err := parse([]byte{})
+		if err != nil || len(monitors) != 0 {
+			t.Errorf("%s: got %+v, %v for empty output, want no monitors", name, monitors, err)
+		}
+	}
+}
+
+// Writes the files to the directory, creating their parent directories, e.g. {"card0-DP-1/status": "connected"}.
+func writeSysfsTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadDRMMonitors(t *testing.T) {
+	root := t.TempDir()
+	writeSysfsTree(t, root, map[string]string{
+		"card0-DP-1/status":     "connected\n",
+		"card0-DP-1/modes":      "2560x1440\n1920x1080\n1280x720\n",
+		"card0-HDMI-A-1/status": "disconnected\n",
+		"card0-HDMI-A-1/modes":  "",
+		"card1-eDP-1/status":    "connected\n",
+		"card1-eDP-1/modes":     "1920x1200i\n",
+		"card1-DP-2/status":     "connected\n",
+		"card0/dev":             "226:0\n",
+		"version":               "drm 1.1.0 20060810\n",
+	})
+
+	monitors, err := readDRMMonitors(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MonitorInfo{
+		{Name: "DP-1", Width: 2560, Height: 1440, Source: "drm"},
+		{Name: "DP-2", Source: "drm"},
+		{Name: "eDP-1", Width: 1920, Height: 1200, Source: "drm"},
+	}
+	if !reflect.DeepEqual(monitors, want) {
+		t.Errorf("got %+v, want %+v", monitors, want)
+	}
+}
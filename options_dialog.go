@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/diamondburned/gotk4/pkg/core/glib"
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 )
@@ -45,14 +50,22 @@ func showOptionsDialog() {
 		} else if filterRequired {
 			filterWallpapersBySearch(SearchQuery)
 		}
+		refreshOutputDropdown()
+		if UseDaemon {
+			// so the daemon uses the changed options
+			go saveConfigWithDaemon()
+		}
 		return false
 	})
 
 	notebook := gtk.NewNotebook()
 
-	notebook.AppendPage(createUIPage(), gtk.NewLabel("User Interface"))
-	notebook.AppendPage(createConstantsPage(), gtk.NewLabel("Constants"))
-	notebook.AppendPage(createPostProcessingPage(), gtk.NewLabel("Post Processing"))
+	notebook.AppendPage(newScrollablePage(createUIPage()), gtk.NewLabel("User Interface"))
+	notebook.AppendPage(newScrollablePage(createConstantsPage()), gtk.NewLabel("Constants"))
+	notebook.AppendPage(newScrollablePage(createEnginePage()), gtk.NewLabel("Engine"))
+	notebook.AppendPage(newScrollablePage(createPowerPage()), gtk.NewLabel("Power"))
+	notebook.AppendPage(newScrollablePage(createPostProcessingPage()), gtk.NewLabel("Post Processing"))
+	notebook.AppendPage(newScrollablePage(createProfilesPage()), gtk.NewLabel("Profiles"))
 
 	Dialog.SetChild(notebook)
 	Dialog.SetTransientFor(&MainWindow.Window)
@@ -84,6 +97,17 @@ func createUIPage() *gtk.Box {
 	})
 	uiPage.Append(hideBrokenToggle)
 
+	uiPage.Append(addNewSectionLabel("Hide Heavy Wallpapers (average CPU usage in %; 0 = show all)"))
+
+	maxAverageCPUSpinButton := gtk.NewSpinButtonWithRange(0, 1000, 5)
+	maxAverageCPUSpinButton.SetValue(float64(Config.SavedUIState.MaxAverageCPU))
+	maxAverageCPUSpinButton.SetHAlign(gtk.AlignStart)
+	maxAverageCPUSpinButton.Connect("value-changed", func() {
+		Config.SavedUIState.MaxAverageCPU = int64(maxAverageCPUSpinButton.Value())
+		filterRequired = true
+	})
+	uiPage.Append(maxAverageCPUSpinButton)
+
 	uiPage.Append(addNewSectionLabel("Quick Actions"))
 
 	restoreButton := gtk.NewButtonWithLabel("Restore Last Set")
@@ -92,7 +116,7 @@ func createUIPage() *gtk.Box {
 	restoreButton.SetHAlign(gtk.AlignStart)
 	restoreButton.Connect("clicked", func() {
 		log.Println("Restoring last set wallpaper...")
-		go restoreWallpaper()
+		applyOrForward("restore", "restore", nil, restoreWallpaper)
 	})
 	uiPage.Append(restoreButton)
 
@@ -120,7 +144,9 @@ func createUIPage() *gtk.Box {
 		dialog.Connect("response", func(response gtk.ResponseType) {
 			if response == gtk.ResponseYes {
 				log.Println("Resetting broken wallpapers...")
-				Config.SavedUIState.Broken = []string{}
+				if err := resetBroken(); err != nil {
+					log.Printf("Error resetting broken wallpapers: %v", err)
+				}
 				reloadRequired = true
 				refreshRequired = true
 			} else {
@@ -157,7 +183,9 @@ func createUIPage() *gtk.Box {
 		dialog.Connect("response", func(response gtk.ResponseType) {
 			if response == gtk.ResponseYes {
 				log.Println("Resetting favorites...")
-				Config.SavedUIState.Favorites = []string{}
+				if err := resetFavorites(); err != nil {
+					log.Printf("Error resetting favorites: %v", err)
+				}
 				reloadRequired = true
 				refreshRequired = true
 			} else {
@@ -195,6 +223,32 @@ func createConstantsPage() *gtk.Box {
 	})
 	constantsPage.Append(discardProcessLogsToggle)
 
+	engineLogsToggle := gtk.NewCheckButtonWithLabel("Write linux-wallpaperengine Logs per Wallpaper (View Engine Log in the right-click menu)")
+	engineLogsToggle.SetHAlign(gtk.AlignStart)
+	engineLogsToggle.SetActive(Config.Constants.EngineLogs)
+	engineLogsToggle.Connect("toggled", func() {
+		Config.Constants.EngineLogs = engineLogsToggle.Active()
+	})
+	constantsPage.Append(engineLogsToggle)
+
+	watchHotplugToggle := gtk.NewCheckButtonWithLabel("Re-apply Wallpapers When Outputs Are Connected or Disconnected")
+	watchHotplugToggle.SetHAlign(gtk.AlignStart)
+	watchHotplugToggle.SetActive(Config.Constants.WatchHotplug)
+	watchHotplugToggle.Connect("toggled", func() {
+		Config.Constants.WatchHotplug = watchHotplugToggle.Active()
+	})
+	constantsPage.Append(watchHotplugToggle)
+
+	constantsPage.Append(addNewSectionLabel("Kill Timeout (seconds to wait before force killing linux-wallpaperengine)"))
+
+	killTimeoutSpinButton := gtk.NewSpinButtonWithRange(1, 60, 1)
+	killTimeoutSpinButton.SetValue(float64(Config.Constants.KillTimeout))
+	killTimeoutSpinButton.SetHAlign(gtk.AlignStart)
+	killTimeoutSpinButton.Connect("value-changed", func() {
+		Config.Constants.KillTimeout = int64(killTimeoutSpinButton.Value())
+	})
+	constantsPage.Append(killTimeoutSpinButton)
+
 	constantsPage.Append(addNewSectionLabel("Wallpaper Engine Binary"))
 
 	wallpaperEngineBinaryEntry := gtk.NewEntry()
@@ -294,9 +348,266 @@ func createConstantsPage() *gtk.Box {
 	wallpaperEngineAssetsBox.Append(wallpaperEngineAssetsButton)
 	wallpaperEngineAssetsBox.Append(wallpaperEngineAssetsEntry)
 
+	constantsPage.Append(addNewSectionLabel("Outputs (e.g. HDMI-A-1, eDP-1)"))
+
+	outputsList := gtk.NewFlowBox()
+	outputsList.SetHAlign(gtk.AlignFill)
+	outputsList.SetOrientation(gtk.OrientationHorizontal)
+	outputsList.SetSelectionMode(gtk.SelectionNone)
+	outputsList.SetColumnSpacing(4)
+	outputsList.SetRowSpacing(4)
+	outputsList.SetMinChildrenPerLine(1)
+	outputsList.SetMaxChildrenPerLine(1)
+	outputsList.SetHomogeneous(true)
+	outputsList.SetHExpand(true)
+	outputsList.SetVExpand(false)
+	refreshOutputsList(outputsList)
+	constantsPage.Append(outputsList)
+
+	constantsPage.Append(addNewSectionLabel("Detected Outputs"))
+
+	detectedOutputsList := gtk.NewFlowBox()
+	detectedOutputsList.SetHAlign(gtk.AlignFill)
+	detectedOutputsList.SetOrientation(gtk.OrientationHorizontal)
+	detectedOutputsList.SetSelectionMode(gtk.SelectionNone)
+	detectedOutputsList.SetColumnSpacing(4)
+	detectedOutputsList.SetRowSpacing(4)
+	detectedOutputsList.SetMinChildrenPerLine(1)
+	detectedOutputsList.SetMaxChildrenPerLine(1)
+	detectedOutputsList.SetHomogeneous(true)
+	detectedOutputsList.SetHExpand(true)
+	detectedOutputsList.SetVExpand(false)
+	detectedOutputsList.Append(gtk.NewLabel("Detecting outputs..."))
+	constantsPage.Append(detectedOutputsList)
+
+	// detection runs external commands, so do not block the UI with it
+	go func() {
+		monitors, err := listMonitors()
+		glib.IdleAdd(func() {
+			refreshDetectedOutputsList(detectedOutputsList, outputsList, monitors, err)
+		})
+	}()
+
 	return constantsPage
 }
 
+// Creates the Engine page, containing options for Config.Engine
+//
+// These are the defaults for every wallpaper, and can be overridden per wallpaper from its right-click menu.
+func createEnginePage() *gtk.Box {
+	enginePage := gtk.NewBox(gtk.OrientationVertical, 0)
+	enginePage.SetMarginTop(10)
+	enginePage.SetMarginBottom(10)
+	enginePage.SetMarginStart(10)
+	enginePage.SetMarginEnd(10)
+	enginePage.SetSpacing(10)
+	enginePage.SetHExpand(true)
+	enginePage.SetVExpand(true)
+	enginePage.SetHAlign(gtk.AlignFill)
+
+	capabilitiesLabel := gtk.NewLabel("")
+	capabilitiesLabel.SetHAlign(gtk.AlignStart)
+	capabilitiesLabel.SetWrap(true)
+	capabilitiesLabel.SetText("Detecting the options supported by " + Config.Constants.LinuxWallpaperEngineBin + "...")
+	withEngineCapabilities(func(capabilities *EngineCapabilities, err error) {
+		if err != nil {
+			capabilitiesLabel.SetText("Could not detect the options supported by " + Config.Constants.LinuxWallpaperEngineBin + ", every option is assumed to be supported.")
+			capabilitiesLabel.SetTooltipText(err.Error())
+		} else {
+			capabilitiesLabel.SetText(fmt.Sprintf("Detected %d options supported by %s. Unsupported options are greyed out and left out when applying wallpapers.", len(capabilities.Options), capabilities.BinaryPath))
+		}
+	})
+	enginePage.Append(capabilitiesLabel)
+
+	enginePage.Append(addNewSectionLabel("Toggles"))
+
+	noFullscreenPauseToggle := gtk.NewCheckButtonWithLabel("Keep Rendering While a Fullscreen Window Is Open")
+	noFullscreenPauseToggle.SetHAlign(gtk.AlignStart)
+	noFullscreenPauseToggle.SetActive(Config.Engine.NoFullscreenPause)
+	noFullscreenPauseToggle.Connect("toggled", func() {
+		Config.Engine.NoFullscreenPause = noFullscreenPauseToggle.Active()
+	})
+	disableIfUnsupported(noFullscreenPauseToggle, "--no-fullscreen-pause")
+	enginePage.Append(noFullscreenPauseToggle)
+
+	disableMouseToggle := gtk.NewCheckButtonWithLabel("Disable Mouse Interaction")
+	disableMouseToggle.SetHAlign(gtk.AlignStart)
+	disableMouseToggle.SetActive(Config.Engine.DisableMouse)
+	disableMouseToggle.Connect("toggled", func() {
+		Config.Engine.DisableMouse = disableMouseToggle.Active()
+	})
+	disableIfUnsupported(disableMouseToggle, "--disable-mouse")
+	enginePage.Append(disableMouseToggle)
+
+	noAutomuteToggle := gtk.NewCheckButtonWithLabel("Keep Audio Playing While Other Applications Play Audio")
+	noAutomuteToggle.SetHAlign(gtk.AlignStart)
+	noAutomuteToggle.SetActive(Config.Engine.NoAutomute)
+	noAutomuteToggle.Connect("toggled", func() {
+		Config.Engine.NoAutomute = noAutomuteToggle.Active()
+	})
+	disableIfUnsupported(noAutomuteToggle, "--noautomute")
+	enginePage.Append(noAutomuteToggle)
+
+	noAudioProcessingToggle := gtk.NewCheckButtonWithLabel("Disable Audio Processing (for audio-reactive wallpapers)")
+	noAudioProcessingToggle.SetHAlign(gtk.AlignStart)
+	noAudioProcessingToggle.SetActive(Config.Engine.NoAudioProcessing)
+	noAudioProcessingToggle.Connect("toggled", func() {
+		Config.Engine.NoAudioProcessing = noAudioProcessingToggle.Active()
+	})
+	disableIfUnsupported(noAudioProcessingToggle, "--no-audio-processing")
+	enginePage.Append(noAudioProcessingToggle)
+
+	enginePage.Append(addNewSectionLabel("Frame Rate Limit (0 = let linux-wallpaperengine decide)"))
+
+	fpsSpinButton := gtk.NewSpinButtonWithRange(0, 240, 1)
+	fpsSpinButton.SetValue(float64(Config.Engine.FPS))
+	fpsSpinButton.SetHAlign(gtk.AlignStart)
+	fpsSpinButton.Connect("value-changed", func() {
+		Config.Engine.FPS = int64(fpsSpinButton.Value())
+	})
+	disableIfUnsupported(fpsSpinButton, "--fps")
+	enginePage.Append(fpsSpinButton)
+
+	enginePage.Append(addNewSectionLabel("Crash Recovery"))
+
+	supervisorToggle := gtk.NewCheckButtonWithLabel("Restart linux-wallpaperengine When It Crashes")
+	supervisorToggle.SetHAlign(gtk.AlignStart)
+	supervisorToggle.SetActive(Config.Supervisor.Enabled)
+	supervisorToggle.Connect("toggled", func() {
+		Config.Supervisor.Enabled = supervisorToggle.Active()
+	})
+	enginePage.Append(supervisorToggle)
+
+	brokenDetectionToggle := gtk.NewCheckButtonWithLabel("Automatically Mark Wallpapers as Broken When linux-wallpaperengine Fails to Run Them")
+	brokenDetectionToggle.SetHAlign(gtk.AlignStart)
+	brokenDetectionToggle.SetActive(Config.BrokenDetection.Enabled)
+	brokenDetectionToggle.SetTooltipText("Checks the exit status and log of linux-wallpaperengine for the first seconds after applying; the patterns can be changed in config.toml")
+	brokenDetectionToggle.Connect("toggled", func() {
+		Config.BrokenDetection.Enabled = brokenDetectionToggle.Active()
+	})
+	enginePage.Append(brokenDetectionToggle)
+
+	crashLimitBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	crashLimitBox.Append(gtk.NewLabel("Give up after"))
+	maxCrashesSpinButton := gtk.NewSpinButtonWithRange(1, 20, 1)
+	maxCrashesSpinButton.SetValue(float64(Config.Supervisor.MaxCrashes))
+	maxCrashesSpinButton.Connect("value-changed", func() {
+		Config.Supervisor.MaxCrashes = int64(maxCrashesSpinButton.Value())
+	})
+	crashLimitBox.Append(maxCrashesSpinButton)
+	crashLimitBox.Append(gtk.NewLabel("crashes within"))
+	crashWindowSpinButton := gtk.NewSpinButtonWithRange(10, 3600, 10)
+	crashWindowSpinButton.SetValue(float64(Config.Supervisor.CrashWindow))
+	crashWindowSpinButton.Connect("value-changed", func() {
+		Config.Supervisor.CrashWindow = int64(crashWindowSpinButton.Value())
+	})
+	crashLimitBox.Append(crashWindowSpinButton)
+	crashLimitBox.Append(gtk.NewLabel("seconds, and mark the wallpaper as broken"))
+	enginePage.Append(crashLimitBox)
+
+	safeWallpaperEntry := gtk.NewEntry()
+	safeWallpaperEntry.SetText(Config.Supervisor.SafeWallpaperId)
+	safeWallpaperEntry.SetHExpand(true)
+	safeWallpaperEntry.SetHAlign(gtk.AlignFill)
+	safeWallpaperEntry.SetPlaceholderText("Safe wallpaper ID to apply instead of a crashing wallpaper (empty = none)")
+	safeWallpaperEntry.Connect("changed", func() {
+		Config.Supervisor.SafeWallpaperId = strings.TrimSpace(safeWallpaperEntry.Text())
+	})
+	enginePage.Append(safeWallpaperEntry)
+
+	return enginePage
+}
+
+// Creates the Power page, containing options for Config.PowerPolicy
+func createPowerPage() *gtk.Box {
+	powerPage := gtk.NewBox(gtk.OrientationVertical, 0)
+	powerPage.SetMarginTop(10)
+	powerPage.SetMarginBottom(10)
+	powerPage.SetMarginStart(10)
+	powerPage.SetMarginEnd(10)
+	powerPage.SetSpacing(10)
+	powerPage.SetHExpand(true)
+	powerPage.SetVExpand(true)
+	powerPage.SetHAlign(gtk.AlignFill)
+
+	powerPage.Append(addNewSectionLabel("Battery"))
+
+	powerPolicyToggle := gtk.NewCheckButtonWithLabel("Change Wallpapers While on Battery (restored on AC)")
+	powerPolicyToggle.SetHAlign(gtk.AlignStart)
+	powerPolicyToggle.SetActive(Config.PowerPolicy.Enabled)
+	powerPolicyToggle.Connect("toggled", func() {
+		Config.PowerPolicy.Enabled = powerPolicyToggle.Active()
+	})
+	powerPage.Append(powerPolicyToggle)
+
+	onBatteryBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	onBatteryBox.Append(gtk.NewLabel("On battery:"))
+	onBatteryDropdown := gtk.NewDropDown(gtk.NewStringList([]string{"Do Nothing", "Lower the Frame Rate", "Mute", "Show the Fallback Wallpaper", "Pause"}), nil)
+	onBatteryDropdown.SetSelected(uint(max(slices.Index(PowerPolicyModes, Config.PowerPolicy.OnBattery), 0)))
+	onBatteryDropdown.Connect("notify::selected", func() {
+		Config.PowerPolicy.OnBattery = PowerPolicyModes[onBatteryDropdown.Selected()]
+	})
+	onBatteryBox.Append(onBatteryDropdown)
+	powerPage.Append(onBatteryBox)
+
+	powerPage.Append(addNewSectionLabel("Frame Rate Limit on Battery"))
+
+	batteryFPSSpinButton := gtk.NewSpinButtonWithRange(1, 240, 1)
+	batteryFPSSpinButton.SetValue(float64(Config.PowerPolicy.BatteryFPS))
+	batteryFPSSpinButton.SetHAlign(gtk.AlignStart)
+	batteryFPSSpinButton.Connect("value-changed", func() {
+		Config.PowerPolicy.BatteryFPS = int64(batteryFPSSpinButton.Value())
+	})
+	disableIfUnsupported(batteryFPSSpinButton, "--fps")
+	powerPage.Append(batteryFPSSpinButton)
+
+	powerPage.Append(addNewSectionLabel("Fallback Wallpaper"))
+
+	fallbackWallpaperEntry := gtk.NewEntry()
+	fallbackWallpaperEntry.SetText(Config.PowerPolicy.FallbackWallpaperId)
+	fallbackWallpaperEntry.SetHExpand(true)
+	fallbackWallpaperEntry.SetHAlign(gtk.AlignFill)
+	fallbackWallpaperEntry.SetPlaceholderText("Lightweight wallpaper ID to show on battery")
+	fallbackWallpaperEntry.Connect("changed", func() {
+		Config.PowerPolicy.FallbackWallpaperId = strings.TrimSpace(fallbackWallpaperEntry.Text())
+	})
+	powerPage.Append(fallbackWallpaperEntry)
+
+	powerPage.Append(addNewSectionLabel("Game Mode"))
+
+	gameModeToggle := gtk.NewCheckButtonWithLabel("Pause or Stop Wallpapers While One of the Processes Below Is Running")
+	gameModeToggle.SetHAlign(gtk.AlignStart)
+	gameModeToggle.SetActive(Config.GameMode.Enabled)
+	gameModeToggle.Connect("toggled", func() {
+		Config.GameMode.Enabled = gameModeToggle.Active()
+	})
+	powerPage.Append(gameModeToggle)
+
+	gameModeActionBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	gameModeActionBox.Append(gtk.NewLabel("While running:"))
+	gameModeActionDropdown := gtk.NewDropDown(gtk.NewStringList([]string{"Pause (resume afterwards)", "Stop (restore afterwards)"}), nil)
+	gameModeActionDropdown.SetSelected(uint(max(slices.Index(GameModeActions, Config.GameMode.Action), 0)))
+	gameModeActionDropdown.Connect("notify::selected", func() {
+		Config.GameMode.Action = GameModeActions[gameModeActionDropdown.Selected()]
+	})
+	gameModeActionBox.Append(gameModeActionDropdown)
+	powerPage.Append(gameModeActionBox)
+
+	powerPage.Append(addNewSectionLabel("Process Names (e.g. blender, obs)"))
+
+	gameModeProcessesList := newListFlowBox()
+	refreshStringList(gameModeProcessesList, &Config.GameMode.Processes, "Process name, e.g. blender")
+	powerPage.Append(gameModeProcessesList)
+
+	powerPage.Append(addNewSectionLabel("Command Line Patterns (regular expressions)"))
+
+	gameModePatternsList := newListFlowBox()
+	refreshStringList(gameModePatternsList, &Config.GameMode.Patterns, "Regular expression, e.g. steamapps/common/")
+	powerPage.Append(gameModePatternsList)
+
+	return powerPage
+}
+
 // Creates the Post Processing page, containing options for Config.PostProcessing
 func createPostProcessingPage() *gtk.Box {
 	postProcessingPage := gtk.NewBox(gtk.OrientationVertical, 0)
@@ -375,6 +686,7 @@ func createPostProcessingPage() *gtk.Box {
 	screenshotFileList.SetHExpand(true)
 	screenshotFileList.SetVExpand(false)
 	refreshScreenshotFilesList(screenshotFileList)
+	disableIfUnsupported(screenshotFileList, "--screenshot")
 	postProcessingPage.Append(screenshotFileList)
 
 	postProcessingPage.Append(addNewSectionLabel("Post Command"))
@@ -393,6 +705,91 @@ func createPostProcessingPage() *gtk.Box {
 	return postProcessingPage
 }
 
+// Creates the Profiles page, containing options for Config.Profiles
+func createProfilesPage() *gtk.Box {
+	profilesPage := gtk.NewBox(gtk.OrientationVertical, 0)
+	profilesPage.SetMarginTop(10)
+	profilesPage.SetMarginBottom(10)
+	profilesPage.SetMarginStart(10)
+	profilesPage.SetMarginEnd(10)
+	profilesPage.SetSpacing(10)
+	profilesPage.SetHExpand(true)
+	profilesPage.SetVExpand(true)
+	profilesPage.SetHAlign(gtk.AlignFill)
+
+	profilesPage.Append(addNewSectionLabel("Save Current Layout"))
+
+	descriptionLabel := gtk.NewLabel("Saves the wallpaper, volume and scaling of every connected output. A profile is applied automatically when exactly its outputs are connected.")
+	descriptionLabel.SetHAlign(gtk.AlignStart)
+	descriptionLabel.SetWrap(true)
+	profilesPage.Append(descriptionLabel)
+
+	profilesList := gtk.NewFlowBox()
+
+	saveProfileBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+	saveProfileBox.SetHExpand(true)
+	saveProfileBox.SetVExpand(false)
+	profilesPage.Append(saveProfileBox)
+
+	saveProfileWarning := gtk.NewImageFromIconName("dialog-warning-symbolic")
+	saveProfileWarning.SetHExpand(false)
+	saveProfileWarning.SetVExpand(false)
+	saveProfileWarning.SetHAlign(gtk.AlignStart)
+	saveProfileWarning.SetSizeRequest(24, 24)
+	saveProfileWarning.SetVisible(false)
+
+	saveProfileEntry := gtk.NewEntry()
+	saveProfileEntry.SetEditable(true)
+	saveProfileEntry.SetHExpand(true)
+	saveProfileEntry.SetHAlign(gtk.AlignFill)
+	saveProfileEntry.SetPlaceholderText("Profile name, e.g. docked, laptop-only, presentation")
+
+	saveProfileButton := gtk.NewButtonWithLabel("Save")
+	saveProfileButton.SetHExpand(false)
+	saveProfileButton.SetVExpand(false)
+	saveProfileButton.SetHAlign(gtk.AlignEnd)
+	saveProfileButton.Connect("clicked", func() {
+		if err := saveCurrentLayoutAsProfile(saveProfileEntry.Text()); err != nil {
+			log.Printf("Failed to save profile: %v", err)
+			saveProfileWarning.SetTooltipText(err.Error())
+			saveProfileWarning.SetVisible(true)
+			return
+		}
+		saveProfileWarning.SetVisible(false)
+		saveProfileEntry.SetText("")
+		refreshProfilesList(profilesList)
+	})
+	saveProfileBox.Append(saveProfileEntry)
+	saveProfileBox.Append(saveProfileWarning)
+	saveProfileBox.Append(saveProfileButton)
+
+	profilesPage.Append(addNewSectionLabel("Saved Profiles"))
+
+	profilesList.SetHAlign(gtk.AlignFill)
+	profilesList.SetOrientation(gtk.OrientationHorizontal)
+	profilesList.SetSelectionMode(gtk.SelectionNone)
+	profilesList.SetColumnSpacing(4)
+	profilesList.SetRowSpacing(4)
+	profilesList.SetMinChildrenPerLine(1)
+	profilesList.SetMaxChildrenPerLine(1)
+	profilesList.SetHExpand(true)
+	profilesList.SetVExpand(false)
+	refreshProfilesList(profilesList)
+	profilesPage.Append(profilesList)
+
+	return profilesPage
+}
+
+// Helper function to wrap a page in a vertically scrollable window, so long pages do not grow the dialog past the screen.
+func newScrollablePage(page *gtk.Box) *gtk.ScrolledWindow {
+	scrolledWindow := gtk.NewScrolledWindow()
+	scrolledWindow.SetPolicy(gtk.PolicyNever, gtk.PolicyAutomatic)
+	scrolledWindow.SetHExpand(true)
+	scrolledWindow.SetVExpand(true)
+	scrolledWindow.SetChild(page)
+	return scrolledWindow
+}
+
 // Helper function to create a label with the provided text to ensure uniform styles.
 func addNewSectionLabel(text string) *gtk.Label {
 	label := gtk.NewLabel(text)
@@ -404,6 +801,298 @@ func addNewSectionLabel(text string) *gtk.Label {
 	return label
 }
 
+// Detects the options supported by Config.Constants.LinuxWallpaperEngineBin in the background, as running it with --help
+// can take a few seconds, and calls done with the result on the GTK main thread.
+func withEngineCapabilities(done func(capabilities *EngineCapabilities, err error)) {
+	go func() {
+		capabilities, err := detectEngineCapabilities()
+		glib.IdleAdd(func() {
+			done(capabilities, err)
+		})
+	}()
+}
+
+// Greys out the widget once Config.Constants.LinuxWallpaperEngineBin turns out not to support the given option, explaining why in its tooltip.
+func disableIfUnsupported(widget gtk.Widgetter, option string) {
+	withEngineCapabilities(func(capabilities *EngineCapabilities, err error) {
+		// like engineSupports, a failed detection leaves every option enabled
+		if err != nil || slices.Contains(capabilities.Options, option) {
+			return
+		}
+
+		baseWidget := gtk.BaseWidget(widget)
+		baseWidget.SetSensitive(false)
+		baseWidget.SetTooltipText(fmt.Sprintf("%s does not support %s, so this option is ignored. Update linux-wallpaperengine to use it.", Config.Constants.LinuxWallpaperEngineBin, option))
+	})
+}
+
+// Helper function to create the items for the profiles list.
+//
+// Each profile has a header with its name and a remove button,
+// followed by a row per output with the wallpaper ID, volume, and scaling mode of that output.
+func refreshProfilesList(profilesList *gtk.FlowBox) {
+	profilesList.RemoveAll()
+
+	if len(Config.Profiles) == 0 {
+		label := gtk.NewLabel("No profiles saved yet.")
+		label.SetHAlign(gtk.AlignStart)
+		profilesList.Append(label)
+		return
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(Config.Profiles)) {
+		profile := Config.Profiles[name]
+
+		vBox := gtk.NewBox(gtk.OrientationVertical, 4)
+		vBox.SetHExpand(true)
+		vBox.SetVExpand(false)
+
+		headerBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+		headerBox.SetHExpand(true)
+
+		nameLabel := gtk.NewLabel(name)
+		if name == Config.SavedUIState.ActiveProfile {
+			nameLabel.SetMarkup("<b>" + escapeMarkup(name) + "</b> <i>(active)</i>")
+		} else {
+			nameLabel.SetMarkup("<b>" + escapeMarkup(name) + "</b>")
+		}
+		nameLabel.SetHExpand(true)
+		nameLabel.SetHAlign(gtk.AlignStart)
+		headerBox.Append(nameLabel)
+
+		removeButton := gtk.NewButtonFromIconName("edit-delete")
+		removeButton.SetHExpand(false)
+		removeButton.SetVExpand(false)
+		removeButton.SetHAlign(gtk.AlignEnd)
+		removeButton.SetSizeRequest(24, 24)
+		removeButton.Connect("clicked", func() {
+			delete(Config.Profiles, name)
+			if Config.SavedUIState.ActiveProfile == name {
+				Config.SavedUIState.ActiveProfile = ""
+			}
+			refreshProfilesList(profilesList)
+		})
+		headerBox.Append(removeButton)
+		vBox.Append(headerBox)
+
+		for _, output := range slices.Sorted(maps.Keys(profile.Outputs)) {
+			outputBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+			outputBox.SetHExpand(true)
+			outputBox.SetMarginStart(10)
+
+			outputLabel := gtk.NewLabel(output)
+			outputLabel.SetHAlign(gtk.AlignStart)
+			outputLabel.SetSizeRequest(100, -1)
+			outputBox.Append(outputLabel)
+
+			wallpaperIdEntry := gtk.NewEntry()
+			wallpaperIdEntry.SetText(profile.Outputs[output].WallpaperId)
+			wallpaperIdEntry.SetEditable(true)
+			wallpaperIdEntry.SetHExpand(true)
+			wallpaperIdEntry.SetHAlign(gtk.AlignFill)
+			wallpaperIdEntry.SetPlaceholderText("Wallpaper ID")
+			wallpaperIdEntry.Connect("changed", func() {
+				settings := Config.Profiles[name].Outputs[output]
+				settings.WallpaperId = wallpaperIdEntry.Text()
+				Config.Profiles[name].Outputs[output] = settings
+			})
+			outputBox.Append(wallpaperIdEntry)
+
+			volumeSpinButton := gtk.NewSpinButtonWithRange(0, 100, 1)
+			volumeSpinButton.SetValue(float64(profile.Outputs[output].Volume))
+			volumeSpinButton.SetTooltipText("Volume")
+			volumeSpinButton.Connect("value-changed", func() {
+				settings := Config.Profiles[name].Outputs[output]
+				settings.Volume = int64(volumeSpinButton.Value())
+				Config.Profiles[name].Outputs[output] = settings
+			})
+			outputBox.Append(volumeSpinButton)
+
+			// the first item is an empty scaling mode, which uses the wallpaper's scaling mode
+			scalingModes := append([]string{""}, ScalingModes...)
+			scalingDropdown := gtk.NewDropDown(gtk.NewStringList(append([]string{"wallpaper default"}, ScalingModes...)), nil)
+			scalingDropdown.SetSelected(uint(max(slices.Index(scalingModes, profile.Outputs[output].Scaling), 0)))
+			scalingDropdown.SetTooltipText("Scaling")
+			scalingDropdown.Connect("notify::selected", func() {
+				settings := Config.Profiles[name].Outputs[output]
+				settings.Scaling = scalingModes[scalingDropdown.Selected()]
+				Config.Profiles[name].Outputs[output] = settings
+			})
+			outputBox.Append(scalingDropdown)
+
+			vBox.Append(outputBox)
+		}
+
+		profilesList.Append(vBox)
+	}
+}
+
+// Helper function to create the items for the outputs list.
+//
+// Each item has a text input with the output name, and a remove button to remove the output.
+//
+// Also adds an "Add" button to add another output to the list, appending Config.Constants.Outputs and refreshing the list.
+// Empty outputs are removed by validateConfig() when the dialog is closed.
+func refreshOutputsList(outputsList *gtk.FlowBox) {
+	outputsList.RemoveAll()
+
+	for i, output := range Config.Constants.Outputs {
+		hBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+		hBox.SetHExpand(true)
+		hBox.SetVExpand(false)
+
+		entry := gtk.NewEntry()
+		entry.SetText(output)
+		entry.SetEditable(true)
+		entry.SetHExpand(true)
+		entry.SetHAlign(gtk.AlignFill)
+		entry.SetPlaceholderText("Output name, e.g. HDMI-A-1")
+		entry.Connect("changed", func() {
+			Config.Constants.Outputs[i] = entry.Text()
+		})
+		hBox.Append(entry)
+
+		removeButton := gtk.NewButtonFromIconName("edit-delete")
+		removeButton.SetHExpand(false)
+		removeButton.SetVExpand(false)
+		removeButton.SetHAlign(gtk.AlignEnd)
+		removeButton.SetSizeRequest(24, 24)
+		removeButton.Connect("clicked", func() {
+			Config.Constants.Outputs = append(Config.Constants.Outputs[:i], Config.Constants.Outputs[i+1:]...)
+			refreshOutputsList(outputsList)
+		})
+		hBox.Append(removeButton)
+
+		outputsList.Append(hBox)
+	}
+
+	addButton := gtk.NewButtonFromIconName("list-add")
+	addButton.SetHExpand(true)
+	addButton.SetVExpand(false)
+	addButton.SetHAlign(gtk.AlignFill)
+	addButton.SetSizeRequest(-1, 24)
+	addButton.Connect("clicked", func() {
+		Config.Constants.Outputs = append(Config.Constants.Outputs, "")
+		refreshOutputsList(outputsList)
+	})
+
+	outputsList.Append(addButton)
+}
+
+// Creates a FlowBox with one item per row, used for the editable lists, e.g. refreshStringList.
+func newListFlowBox() *gtk.FlowBox {
+	list := gtk.NewFlowBox()
+	list.SetHAlign(gtk.AlignFill)
+	list.SetOrientation(gtk.OrientationHorizontal)
+	list.SetSelectionMode(gtk.SelectionNone)
+	list.SetColumnSpacing(4)
+	list.SetRowSpacing(4)
+	list.SetMinChildrenPerLine(1)
+	list.SetMaxChildrenPerLine(1)
+	list.SetHomogeneous(true)
+	list.SetHExpand(true)
+	list.SetVExpand(false)
+	return list
+}
+
+// Helper function to create the items for a list of strings in the Config, e.g. Config.GameMode.Processes.
+//
+// Each item has an entry to edit the value and a remove button, followed by a button to add a new item.
+func refreshStringList(list *gtk.FlowBox, values *[]string, placeholder string) {
+	list.RemoveAll()
+
+	for i, value := range *values {
+		hBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+		hBox.SetHExpand(true)
+		hBox.SetVExpand(false)
+
+		entry := gtk.NewEntry()
+		entry.SetText(value)
+		entry.SetEditable(true)
+		entry.SetHExpand(true)
+		entry.SetHAlign(gtk.AlignFill)
+		entry.SetPlaceholderText(placeholder)
+		entry.Connect("changed", func() {
+			(*values)[i] = entry.Text()
+		})
+		hBox.Append(entry)
+
+		removeButton := gtk.NewButtonFromIconName("edit-delete")
+		removeButton.SetHExpand(false)
+		removeButton.SetVExpand(false)
+		removeButton.SetHAlign(gtk.AlignEnd)
+		removeButton.SetSizeRequest(24, 24)
+		removeButton.Connect("clicked", func() {
+			*values = append((*values)[:i], (*values)[i+1:]...)
+			refreshStringList(list, values, placeholder)
+		})
+		hBox.Append(removeButton)
+
+		list.Append(hBox)
+	}
+
+	addButton := gtk.NewButtonFromIconName("list-add")
+	addButton.SetHExpand(true)
+	addButton.SetVExpand(false)
+	addButton.SetHAlign(gtk.AlignFill)
+	addButton.SetSizeRequest(-1, 24)
+	addButton.Connect("clicked", func() {
+		*values = append(*values, "")
+		refreshStringList(list, values, placeholder)
+	})
+
+	list.Append(addButton)
+}
+
+// Helper function to create the items for the detected outputs list.
+//
+// Each item shows the output name and its description, with a button to add it to Config.Constants.Outputs.
+// The button is disabled if the output is already configured.
+func refreshDetectedOutputsList(detectedOutputsList *gtk.FlowBox, outputsList *gtk.FlowBox, monitors []MonitorInfo, err error) {
+	detectedOutputsList.RemoveAll()
+
+	if err != nil || len(monitors) == 0 {
+		if err != nil {
+			log.Printf("Failed to detect outputs: %v", err)
+		}
+		label := gtk.NewLabel("No outputs detected, enter the output names manually above.")
+		label.SetHAlign(gtk.AlignStart)
+		detectedOutputsList.Append(label)
+		return
+	}
+
+	for _, monitor := range monitors {
+		hBox := gtk.NewBox(gtk.OrientationHorizontal, 4)
+		hBox.SetHExpand(true)
+		hBox.SetVExpand(false)
+
+		label := gtk.NewLabel(monitor.Name)
+		label.SetMarkup("<b>" + escapeMarkup(monitor.Name) + "</b>  " + escapeMarkup(describeMonitor(monitor)))
+		label.SetHExpand(true)
+		label.SetHAlign(gtk.AlignStart)
+		label.SetTooltipText("Detected using " + monitor.Source)
+		hBox.Append(label)
+
+		addButton := gtk.NewButtonFromIconName("list-add")
+		addButton.SetHExpand(false)
+		addButton.SetVExpand(false)
+		addButton.SetHAlign(gtk.AlignEnd)
+		addButton.SetSizeRequest(24, 24)
+		addButton.SetTooltipText("Add to outputs")
+		addButton.SetSensitive(!slices.Contains(Config.Constants.Outputs, monitor.Name))
+		addButton.Connect("clicked", func() {
+			if !slices.Contains(Config.Constants.Outputs, monitor.Name) {
+				Config.Constants.Outputs = append(Config.Constants.Outputs, monitor.Name)
+				refreshOutputsList(outputsList)
+			}
+			addButton.SetSensitive(false)
+		})
+		hBox.Append(addButton)
+
+		detectedOutputsList.Append(hBox)
+	}
+}
+
 // Helper function to create the items for the screenshot files list.
 //
 // Each item has a button the change the current item's location, a text input showing the location, and a remove button to remove the item.
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+)
+
+// Returns whether the engines started by the helper are paused, see setEnginesPaused.
+func enginesPaused() bool {
+	processes, err := runningTrackedProcesses()
+	if err != nil {
+		log.Printf("Failed to read tracked processes: %v", err)
+		return false
+	}
+	for _, process := range processes {
+		if process.Paused {
+			return true
+		}
+	}
+	return false
+}
+
+// Pauses (SIGSTOP) or resumes (SIGCONT) the engines started by the helper.
+//
+// The signal is sent to the whole process group of every tracked process, see runDetachedProcess.
+// Whether each process is paused is saved in the tracked processes file, so the GUI and the CLI agree on the state.
+// Returns an error if any process group could not be signalled.
+func setEnginesPaused(paused bool) error {
+	signal := syscall.SIGCONT
+	if paused {
+		signal = syscall.SIGSTOP
+	}
+
+	err := withTrackedProcessesLock(func(stateFile string) error {
+		processes, err := readTrackedProcesses(stateFile)
+		if err != nil {
+			return fmt.Errorf("failed to read tracked processes: %v", err)
+		}
+
+		var anyErr error
+		for i, process := range processes {
+			if !isTrackedProcessRunning(process) {
+				continue
+			}
+			if err := syscall.Kill(-process.PID, signal); err != nil {
+				log.Printf("Error sending %v to process group %d: %v", signal, process.PID, err)
+				anyErr = err
+				continue
+			}
+			log.Printf("Sent %v to process group %d (output %s)", signal, process.PID, process.Output)
+			processes[i].Paused = paused
+		}
+
+		if err := writeTrackedProcesses(stateFile, processes); err != nil {
+			return err
+		}
+		return anyErr
+	})
+	updateGUIPauseState()
+	return err
+}
+
+// Pauses the engines if they are running, resumes them if they are paused.
+// Returns whether the engines are paused afterwards.
+func togglePauseEngines() (bool, error) {
+	paused := !enginesPaused()
+	return paused, setEnginesPaused(paused)
+}
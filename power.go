@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The policies that can be applied while on battery, see Config.PowerPolicy.OnBattery.
+var PowerPolicyModes = []string{"none", "lower_fps", "mute", "fallback", "pause"}
+
+type PowerStatus struct {
+	OnBattery  bool // true if there is a battery and no external power supply is online
+	HasBattery bool
+	Capacity   int // the lowest capacity of the batteries in percent, -1 if unknown
+}
+
+// The power policy currently applied by watchPowerSupply; "none" while on AC.
+var activePowerPolicy string = "none"
+
+// Whether the engines were paused by the power policy, so only those are resumed on AC.
+var pausedByPowerPolicy bool = false
+
+// Returns the power policy currently applied, see activePowerPolicy.
+func currentPowerPolicy() string {
+	policyStateMutex.Lock()
+	defer policyStateMutex.Unlock()
+	return activePowerPolicy
+}
+
+// Sets activePowerPolicy; must be called while holding policyMutex.
+func setActivePowerPolicy(policy string) {
+	policyStateMutex.Lock()
+	activePowerPolicy = policy
+	policyStateMutex.Unlock()
+}
+
+// Reads the power supplies in the given sysfs directory (usually /sys/class/power_supply).
+//
+// Every supply is a directory with a "type" file. Batteries ("Battery") report a "capacity",
+// external supplies ("Mains", "USB", ...) report whether they are "online".
+// Batteries of peripherals (with "scope" set to "Device", e.g. a wireless mouse) are ignored.
+func readPowerStatus(root string) (PowerStatus, error) {
+	status := PowerStatus{Capacity: -1}
+
+	supplies, err := os.ReadDir(root)
+	if err != nil {
+		return status, err
+	}
+
+	externalOnline := false
+	for _, supply := range supplies {
+		supplyDir := filepath.Join(root, supply.Name())
+		readValue := func(name string) string {
+			value, err := os.ReadFile(filepath.Join(supplyDir, name))
+			if err != nil {
+				return ""
+			}
+			return strings.TrimSpace(string(value))
+		}
+
+		if readValue("scope") == "Device" {
+			continue
+		}
+
+		switch readValue("type") {
+		case "Battery":
+			status.HasBattery = true
+			if capacity, err := strconv.Atoi(readValue("capacity")); err == nil && (status.Capacity < 0 || capacity < status.Capacity) {
+				status.Capacity = capacity
+			}
+		case "":
+			continue
+		default:
+			if readValue("online") == "1" {
+				externalOnline = true
+			}
+		}
+	}
+
+	status.OnBattery = status.HasBattery && !externalOnline
+	return status, nil
+}
+
+// Returns the FPS to use for a wallpaper with the given FPS limit, taking the active power policy into account.
+// 0 means no limit, as in Config.Engine.FPS.
+func powerPolicyFPS(fps int64) int64 {
+	if currentPowerPolicy() != "lower_fps" {
+		return fps
+	}
+	if fps <= 0 {
+		return Config.PowerPolicy.BatteryFPS
+	}
+	return min(fps, Config.PowerPolicy.BatteryFPS)
+}
+
+// Returns the volume to use, taking the active power policy into account.
+func powerPolicyVolume(volume float64) float64 {
+	if currentPowerPolicy() == "mute" {
+		return 0
+	}
+	return volume
+}
+
+// Returns the wallpaper ID to show instead of the assigned ones under the active power policy,
+// or an empty string if the assigned wallpapers should be shown.
+func powerPolicyWallpaper() string {
+	if currentPowerPolicy() == "fallback" {
+		return Config.PowerPolicy.FallbackWallpaperId
+	}
+	return ""
+}
+
+// Applies the power policy for the given power state, restoring the previous state when switching back to AC.
+//
+// "pause" pauses the engines with setEnginesPaused. The other policies are applied by createWallpaperCommand
+// and applyAssignments, so the wallpapers are re-applied with restoreWallpaper.
+// Returns an error if the policy could not be applied, in which case the previous policy stays active so it can be retried.
+func applyPowerPolicy(onBattery bool) error {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+
+	policy := "none"
+	if onBattery {
+		policy = Config.PowerPolicy.OnBattery
+	}
+	if policy == activePowerPolicy {
+		return nil
+	}
+
+	previousPolicy := activePowerPolicy
+	log.Printf("Switching power policy from %s to %s", previousPolicy, policy)
+
+	if previousPolicy == "pause" && pausedByPowerPolicy {
+		if gameModeActive {
+			// resumed when game mode ends instead, see setGameModeActive
+			pausedByGameMode = true
+		} else if err := setEnginesPaused(false); err != nil {
+			return err
+		}
+		pausedByPowerPolicy = false
+	}
+	setActivePowerPolicy(policy)
+
+	if policy == "pause" {
+		if enginesPaused() {
+			// paused by the user, so leave it paused when switching back to AC
+			return nil
+		}
+		updateGUIStatusText("On battery, pausing wallpapers...")
+		if err := setEnginesPaused(true); err != nil {
+			setActivePowerPolicy(previousPolicy)
+			return err
+		}
+		pausedByPowerPolicy = true
+		return nil
+	}
+
+	if policy == "none" && previousPolicy == "pause" {
+		// resuming was enough
+		return nil
+	}
+	if len(Config.SavedUIState.LastSetIds) == 0 {
+		return nil
+	}
+
+	if deferRestoreForGameMode() {
+		return nil
+	}
+
+	if onBattery {
+		updateGUIStatusText("On battery, re-applying wallpapers...")
+	} else {
+		updateGUIStatusText("On AC, restoring wallpapers...")
+	}
+	if err := <-queueApply("restore for power policy", restoreWallpaper); err != nil && !errors.Is(err, errApplySuperseded) {
+		setActivePowerPolicy(previousPolicy)
+		return err
+	}
+	return nil
+}
+
+// Polls the power supplies in Config.PowerPolicy.SysfsRoot every interval until ctx is cancelled,
+// and applies the power policy when switching between battery and AC, see applyPowerPolicy.
+//
+// Meant to be run as a goroutine.
+func watchPowerSupply(ctx context.Context, interval time.Duration) {
+	lastStatus := PowerStatus{}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// checked here instead of before starting, so toggling it in the options takes effect immediately
+		if Config.PowerPolicy.Enabled || currentPowerPolicy() != "none" {
+			status, err := readPowerStatus(Config.PowerPolicy.SysfsRoot)
+			if err != nil {
+				log.Printf("Failed to read power supplies: %v", err)
+			} else {
+				if status.OnBattery != lastStatus.OnBattery {
+					log.Printf("Power status changed: on battery %v, capacity %d%%", status.OnBattery, status.Capacity)
+				}
+				lastStatus = status
+
+				if err := applyPowerPolicy(status.OnBattery && Config.PowerPolicy.Enabled); err != nil {
+					log.Printf("Failed to apply power policy, retrying later: %v", err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
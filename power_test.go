@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPowerStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  PowerStatus
+	}{
+		{
+			name:  "desktop",
+			files: map[string]string{},
+			want:  PowerStatus{Capacity: -1},
+		},
+		{
+			name: "laptop on AC",
+			files: map[string]string{
+				"AC/type":       "Mains\n",
+				"AC/online":     "1\n",
+				"BAT0/type":     "Battery\n",
+				"BAT0/scope":    "System\n",
+				"BAT0/capacity": "80\n",
+				"BAT0/status":   "Charging\n",
+			},
+			want: PowerStatus{HasBattery: true, Capacity: 80},
+		},
+		{
+			name: "laptop on battery",
+			files: map[string]string{
+				"AC/type":       "Mains\n",
+				"AC/online":     "0\n",
+				"BAT0/type":     "Battery\n",
+				"BAT0/capacity": "57\n",
+			},
+			want: PowerStatus{OnBattery: true, HasBattery: true, Capacity: 57},
+		},
+		{
+			name: "lowest capacity of two batteries",
+			files: map[string]string{
+				"AC/type":       "Mains\n",
+				"AC/online":     "0\n",
+				"BAT0/type":     "Battery\n",
+				"BAT0/capacity": "80\n",
+				"BAT1/type":     "Battery\n",
+				"BAT1/capacity": "35\n",
+			},
+			want: PowerStatus{OnBattery: true, HasBattery: true, Capacity: 35},
+		},
+		{
+			name: "charging over USB-C",
+			files: map[string]string{
+				"AC/type":                            "Mains\n",
+				"AC/online":                          "0\n",
+				"ucsi-source-psy-USBC000:001/type":   "USB\n",
+				"ucsi-source-psy-USBC000:001/online": "1\n",
+				"ucsi-source-psy-USBC000:001/scope":  "Unknown\n",
+				"BAT0/type":                          "Battery\n",
+				"BAT0/capacity":                      "64\n",
+			},
+			want: PowerStatus{HasBattery: true, Capacity: 64},
+		},
+		{
+			name: "peripheral batteries are ignored",
+			files: map[string]string{
+				"hidpp_battery_0/type":     "Battery\n",
+				"hidpp_battery_0/scope":    "Device\n",
+				"hidpp_battery_0/capacity": "10\n",
+			},
+			want: PowerStatus{Capacity: -1},
+		},
+		{
+			name: "battery without capacity",
+			files: map[string]string{
+				"BAT0/type": "Battery\n",
+			},
+			want: PowerStatus{OnBattery: true, HasBattery: true, Capacity: -1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeSysfsTree(t, root, test.files)
+
+			status, err := readPowerStatus(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != test.want {
+				t.Errorf("got %+v, want %+v", status, test.want)
+			}
+		})
+	}
+}
+
+func TestReadPowerStatusMissingRoot(t *testing.T) {
+	if _, err := readPowerStatus(filepath.Join(t.TempDir(), "power_supply")); err == nil {
+		t.Error("expected an error for a missing sysfs directory")
+	}
+}
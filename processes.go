@@ -1,32 +1,95 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
+type TrackedProcess struct {
+	PID         int      `json:"pid"`
+	Output      string   `json:"output"`
+	WallpaperId string   `json:"wallpaper_id"`
+	StartTime   uint64   `json:"start_time"` // in clock ticks since boot, from /proc/<pid>/stat
+	Cmdline     []string `json:"cmdline"`
+	Paused      bool     `json:"paused"` // whether the process group was stopped with SIGSTOP, see setEnginesPaused
+}
+
+type ProcessInfo struct {
+	PID     int
+	Name    string   // the command name from /proc/<pid>/comm, truncated to 15 characters by the kernel
+	Cmdline []string // empty for kernel threads and zombies
+}
+
+// Lists the running processes by reading /proc, skipping processes that exit while reading them.
+func listProcesses() ([]ProcessInfo, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /proc: %v", err)
+	}
+
+	processes := []ProcessInfo{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		comm, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		cmdline, err := readProcessCmdline(pid)
+		if err != nil {
+			continue
+		}
+		if len(cmdline) == 1 && cmdline[0] == "" {
+			cmdline = []string{}
+		}
+
+		processes = append(processes, ProcessInfo{
+			PID:     pid,
+			Name:    strings.TrimSpace(string(comm)),
+			Cmdline: cmdline,
+		})
+	}
+	return processes, nil
+}
+
+// Returns whether the process has the given name, the same way pidof matches it:
+// either its command name, or the base name of its first argument is the given name.
+func processHasName(process ProcessInfo, name string) bool {
+	if process.Name == name {
+		return true
+	}
+	return len(process.Cmdline) > 0 && filepath.Base(process.Cmdline[0]) == name
+}
+
 // Returns a list of PIDs of running processes with the given name.
 // If no processes are found, it returns an empty slice.
 // If an error occurs while checking the processes, it returns an error.
-func getRunningProcessPids(processName string) ([]string, error) {
-	cmd := exec.Command("pidof", processName)
-	output, err := cmd.Output()
+func getRunningProcessPids(processName string) ([]int, error) {
+	processes, err := listProcesses()
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
-			return []string{}, nil
-		}
-		return []string{}, err
+		return []int{}, err
 	}
-	if strings.TrimSpace(string(output)) != "" {
-		pids := strings.Fields(string(output))
-		return pids, nil
+
+	pids := []int{}
+	for _, process := range processes {
+		if processHasName(process, processName) && isProcessRunning(process.PID) {
+			pids = append(pids, process.PID)
+		}
 	}
-	return []string{}, nil
+	return pids, nil
 }
 
 // Starts a process with it's own GPID.
@@ -35,6 +98,15 @@ func getRunningProcessPids(processName string) ([]string, error) {
 //
 // Returns the PID of the detached process as the first return value, and any error as the second. If there is an error, PID will be -1
 func runDetachedProcess(command ...string) (int, error) {
+	cmd, err := startDetachedProcess(command...)
+	if err != nil {
+		return -1, err
+	}
+	return cmd.Process.Pid, nil
+}
+
+// Same as runDetachedProcess, but returns the started exec.Cmd, so the caller can wait for the process to exit.
+func startDetachedProcess(command ...string) (*exec.Cmd, error) {
 	cmd := exec.Command(command[0], command[1:]...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
@@ -55,13 +127,312 @@ func runDetachedProcess(command ...string) (int, error) {
 
 	err := cmd.Start()
 	if err != nil {
-		return -1, fmt.Errorf("error starting detached process: %v", err)
+		return nil, fmt.Errorf("error starting detached process: %v", err)
 	}
 	log.Printf("Detached process started with PID: %d", cmd.Process.Pid)
-	return cmd.Process.Pid, nil
+	return cmd, nil
+}
+
+// Returns the path to the file the engine processes started by the helper are tracked in.
+func trackedProcessesFile() (string, error) {
+	runtimeDir, err := ensureRuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(runtimeDir, "engines.json"), nil
+}
+
+// Runs fn while holding an exclusive lock on the tracked processes file,
+// so the GUI and the CLI do not overwrite each other's changes.
+func withTrackedProcessesLock(fn func(stateFile string) error) error {
+	stateFile, err := trackedProcessesFile()
+	if err != nil {
+		return fmt.Errorf("failed to get the tracked processes file: %v", err)
+	}
+
+	lockFile, err := os.OpenFile(stateFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the tracked processes lock: %v", err)
+	}
+	defer lockFile.Close()
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock the tracked processes file: %v", err)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	return fn(stateFile)
 }
 
-// Tries to kill any running processes with the given name.
+// Reads the tracked processes from the given file. A missing file means no processes are tracked.
+func readTrackedProcesses(stateFile string) ([]TrackedProcess, error) {
+	content, err := os.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return []TrackedProcess{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", stateFile, err)
+	}
+
+	processes := []TrackedProcess{}
+	if err := json.Unmarshal(content, &processes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", stateFile, err)
+	}
+	return processes, nil
+}
+
+// Writes the tracked processes to the given file.
+func writeTrackedProcesses(stateFile string, processes []TrackedProcess) error {
+	content, err := json.MarshalIndent(processes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tracked processes: %v", err)
+	}
+	if err := os.WriteFile(stateFile, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", stateFile, err)
+	}
+	return nil
+}
+
+// Reads the start time of the process, in clock ticks since boot, from /proc/<pid>/stat.
+//
+// Together with the PID this identifies a process, as PIDs are reused once a process exits.
+func readProcessStartTime(pid int) (uint64, error) {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	// the command name (2nd field) is in parentheses and can contain spaces, so split after the last ")"
+	closingParen := bytes.LastIndexByte(stat, ')')
+	if closingParen < 0 {
+		return 0, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(stat[closingParen+1:]))
+	// starttime is the 22nd field, and the fields after the parentheses start at the 3rd
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// Reads the command line of the process from /proc/<pid>/cmdline.
+func readProcessCmdline(pid int) ([]string, error) {
+	cmdline, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), nil
+}
+
+// Returns whether the tracked process is still running, and is still the process that was started.
+// Compares the start time and command line in /proc with the ones recorded when it was started, to not act on reused PIDs.
+func isTrackedProcessRunning(process TrackedProcess) bool {
+	startTime, err := readProcessStartTime(process.PID)
+	if err != nil || startTime != process.StartTime {
+		return false
+	}
+	cmdline, err := readProcessCmdline(process.PID)
+	if err != nil {
+		return false
+	}
+	return slices.Equal(cmdline, process.Cmdline)
+}
+
+// Records a process started by the helper in the tracked processes file, so it can be killed later by tryKillTrackedProcesses.
+// Entries of processes that are no longer running are removed at the same time.
+func trackProcess(pid int, output string, wallpaperId string) error {
+	startTime, err := readProcessStartTime(pid)
+	if err != nil {
+		return fmt.Errorf("failed to read the start time of process %d: %v", pid, err)
+	}
+	cmdline, err := readProcessCmdline(pid)
+	if err != nil {
+		return fmt.Errorf("failed to read the command line of process %d: %v", pid, err)
+	}
+
+	return withTrackedProcessesLock(func(stateFile string) error {
+		processes, err := readTrackedProcesses(stateFile)
+		if err != nil {
+			log.Printf("Discarding tracked processes: %v", err)
+			processes = []TrackedProcess{}
+		}
+
+		processes = slices.DeleteFunc(processes, func(process TrackedProcess) bool {
+			return !isTrackedProcessRunning(process)
+		})
+		processes = append(processes, TrackedProcess{
+			PID:         pid,
+			Output:      output,
+			WallpaperId: wallpaperId,
+			StartTime:   startTime,
+			Cmdline:     cmdline,
+		})
+		return writeTrackedProcesses(stateFile, processes)
+	})
+}
+
+// Returns the tracked processes that are still running.
+func runningTrackedProcesses() ([]TrackedProcess, error) {
+	running := []TrackedProcess{}
+	err := withTrackedProcessesLock(func(stateFile string) error {
+		processes, err := readTrackedProcesses(stateFile)
+		if err != nil {
+			return err
+		}
+		for _, process := range processes {
+			if isTrackedProcessRunning(process) {
+				running = append(running, process)
+			}
+		}
+		return nil
+	})
+	return running, err
+}
+
+// A process (group) to stop with terminateProcesses.
+type terminationTarget struct {
+	description string      // used in logs, e.g. "process group 1234 (output HDMI-A-1)"
+	signalPid   int         // passed to syscall.Kill, negative to signal a whole process group
+	isRunning   func() bool // whether the process is still running
+}
+
+// How often terminateProcesses checks whether the processes have exited.
+var terminationPollInterval = 100 * time.Millisecond
+
+// Returns whether the process with the given PID is running, i.e. exists and is not a zombie.
+func isProcessRunning(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	closingParen := bytes.LastIndexByte(stat, ')')
+	fields := strings.Fields(string(stat[closingParen+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// Stops the targets gracefully, and reports each step with updateGUIStatusText.
+//
+// Sends SIGTERM to every target, then waits up to Config.Constants.KillTimeout seconds for them to exit,
+// so a new engine does not start while the old one still holds the GPU and the layer surface.
+// Targets that are still running after the timeout are sent SIGKILL.
+// Returns an error if a target could not be signalled, or is still running after SIGKILL.
+func terminateProcesses(targets []terminationTarget) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	var anyErr error
+	running := []terminationTarget{}
+	updateGUIStatusText("Stopping linux-wallpaperengine...")
+	for _, target := range targets {
+		if err := syscall.Kill(target.signalPid, syscall.SIGTERM); err != nil {
+			log.Printf("Error sending SIGTERM to %s: %v", target.description, err)
+			anyErr = err
+			continue
+		}
+		log.Printf("Sent SIGTERM to %s", target.description)
+		// a paused (SIGSTOP) process only handles SIGTERM after it is continued
+		syscall.Kill(target.signalPid, syscall.SIGCONT)
+		running = append(running, target)
+	}
+
+	waitForExit := func(timeout time.Duration) {
+		deadline := time.Now().Add(timeout)
+		for len(running) > 0 && time.Now().Before(deadline) {
+			time.Sleep(terminationPollInterval)
+			running = slices.DeleteFunc(running, func(target terminationTarget) bool {
+				return !target.isRunning()
+			})
+		}
+	}
+
+	timeout := time.Duration(Config.Constants.KillTimeout) * time.Second
+	updateGUIStatusText(fmt.Sprintf("Waiting up to %v for linux-wallpaperengine to exit...", timeout))
+	waitForExit(timeout)
+	if len(running) == 0 {
+		log.Println("All processes exited after SIGTERM")
+		return anyErr
+	}
+
+	updateGUIStatusText(fmt.Sprintf("linux-wallpaperengine did not exit within %v, force killing it...", timeout))
+	for _, target := range running {
+		log.Printf("%s did not exit within %v, sending SIGKILL", target.description, timeout)
+		if err := syscall.Kill(target.signalPid, syscall.SIGKILL); err != nil {
+			log.Printf("Error sending SIGKILL to %s: %v", target.description, err)
+			anyErr = err
+		}
+	}
+
+	// SIGKILL cannot be ignored, but the kernel still needs a moment to tear the processes down
+	waitForExit(time.Second)
+	for _, target := range running {
+		log.Printf("%s is still running after SIGKILL", target.description)
+		anyErr = fmt.Errorf("%s is still running after SIGKILL", target.description)
+	}
+	return anyErr
+}
+
+// Tries to kill the engine processes started by the helper, recorded by trackProcess.
+//
+// Every tracked process is verified against /proc first, and its whole process group is stopped with terminateProcesses,
+// as it was started in its own group by runDetachedProcess. Processes started by other tools are left alone.
+// The tracked processes are taken out of the state file before waiting for them to exit, so the lock is not held
+// for up to Config.Constants.KillTimeout; the ones that survive are written back afterwards.
+// Returns an error if it fails to kill the processes. Returns nil if no processes were found or killed successfully.
+func tryKillTrackedProcesses() error {
+	processes := []TrackedProcess{}
+	err := withTrackedProcessesLock(func(stateFile string) error {
+		var err error
+		processes, err = readTrackedProcesses(stateFile)
+		if err != nil {
+			return fmt.Errorf("failed to read tracked processes: %v", err)
+		}
+		return writeTrackedProcesses(stateFile, []TrackedProcess{})
+	})
+	if err != nil {
+		return err
+	}
+
+	targets := []terminationTarget{}
+	for _, process := range processes {
+		if !isTrackedProcessRunning(process) {
+			log.Printf("Tracked process %d is no longer running", process.PID)
+			continue
+		}
+		targets = append(targets, terminationTarget{
+			description: fmt.Sprintf("process group %d (output %s)", process.PID, process.Output),
+			signalPid:   -process.PID,
+			isRunning:   func() bool { return isTrackedProcessRunning(process) },
+		})
+	}
+
+	killErr := terminateProcesses(targets)
+
+	survivors := slices.DeleteFunc(processes, func(process TrackedProcess) bool {
+		return !isTrackedProcessRunning(process)
+	})
+	if len(survivors) > 0 {
+		err := withTrackedProcessesLock(func(stateFile string) error {
+			// processes may have been tracked in the meantime, e.g. by another instance of the helper
+			tracked, err := readTrackedProcesses(stateFile)
+			if err != nil {
+				log.Printf("Discarding tracked processes: %v", err)
+				tracked = []TrackedProcess{}
+			}
+			return writeTrackedProcesses(stateFile, append(tracked, survivors...))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if killErr != nil {
+		return fmt.Errorf("failed to kill one or more processes: %v", killErr)
+	}
+	return nil
+}
+
+// Tries to kill any running processes with the given name, including ones not started by the helper.
+// Used by `kill --all`, see tryKillTrackedProcesses for only killing the processes started by the helper.
+//
 // Returns an error if it fails to kill the processes. Returns nil if no processes were found or killed successfully.
 func tryKillProcesses(processName string) error {
 	runningPids, err := getRunningProcessPids(processName)
@@ -71,26 +442,16 @@ func tryKillProcesses(processName string) error {
 
 	if len(runningPids) > 0 {
 		log.Printf("%s is already running, killing old process(es)...", processName)
-		var anyErr error
+		targets := []terminationTarget{}
 		for _, pid := range runningPids {
-			pidInt, err := strconv.Atoi(pid)
-			if err != nil {
-				log.Printf("Error converting PID '%s' to int: %v", pid, err)
-				anyErr = err
-				break
-			}
-			err = syscall.Kill(pidInt, syscall.SIGTERM)
-			if err != nil {
-				log.Printf("Error killing process with PID %d: %v", pidInt, err)
-				anyErr = err
-				break
-			} else {
-				log.Printf("Successfully killed process with PID %d", pidInt)
-				continue
-			}
+			targets = append(targets, terminationTarget{
+				description: fmt.Sprintf("process %d", pid),
+				signalPid:   pid,
+				isRunning:   func() bool { return isProcessRunning(pid) },
+			})
 		}
-		if anyErr != nil {
-			return fmt.Errorf("failed to kill one or more processes: %v", anyErr)
+		if err := terminateProcesses(targets); err != nil {
+			return fmt.Errorf("failed to kill one or more processes: %v", err)
 		}
 		log.Printf("All running processes for %s have been killed", processName)
 		return nil
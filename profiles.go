@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Returns the name of the profile in the given profiles (e.g. Config.Profiles) whose outputs are exactly the given monitors.
+// Returns false as the second return value if no profile matches.
+//
+// If multiple profiles match, the first one by name is returned.
+func matchProfile(profiles map[string]ProfileStruct, monitors []MonitorInfo) (string, bool) {
+	connected := []string{}
+	for _, monitor := range monitors {
+		connected = append(connected, monitor.Name)
+	}
+	slices.Sort(connected)
+
+	for _, name := range slices.Sorted(maps.Keys(profiles)) {
+		profileOutputs := slices.Sorted(maps.Keys(profiles[name].Outputs))
+		if len(profileOutputs) > 0 && slices.Equal(profileOutputs, connected) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Returns the profile of the given config matching the currently connected monitors, see matchProfile.
+// Returns false as the third return value if the monitors could not be listed or no profile matches.
+func connectedProfile(config *ConfigStruct) (string, ProfileStruct, bool) {
+	if len(config.Profiles) == 0 {
+		return "", ProfileStruct{}, false
+	}
+
+	monitors, err := listMonitors()
+	if err != nil {
+		log.Printf("Failed to list monitors to select a profile: %v", err)
+		return "", ProfileStruct{}, false
+	}
+
+	name, ok := matchProfile(config.Profiles, monitors)
+	if !ok {
+		return "", ProfileStruct{}, false
+	}
+	return name, config.Profiles[name], true
+}
+
+// Saves the currently connected outputs as a profile with the given name, overwriting any profile with the same name.
+//
+// Every output gets its current wallpaper from Config.SavedUIState.LastSetIds.
+// If the output is part of the active profile, its volume and scaling are kept, otherwise the global volume and the wallpaper's scaling are used.
+//
+// The saved profile becomes the active profile, as it matches the connected outputs.
+func saveCurrentLayoutAsProfile(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("profile name cannot be empty")
+	}
+
+	monitors, err := listMonitors()
+	if err != nil {
+		return fmt.Errorf("failed to list connected outputs: %v", err)
+	}
+	if len(monitors) == 0 {
+		return fmt.Errorf("no connected outputs found")
+	}
+
+	activeProfile := Config.Profiles[Config.SavedUIState.ActiveProfile]
+	profile := ProfileStruct{Outputs: map[string]ProfileOutputStruct{}}
+	for _, monitor := range monitors {
+		settings, ok := activeProfile.Outputs[monitor.Name]
+		if !ok {
+			settings = ProfileOutputStruct{Volume: Config.SavedUIState.Volume, Scaling: ""}
+		}
+		settings.WallpaperId = Config.SavedUIState.LastSetIds[monitor.Name]
+		profile.Outputs[monitor.Name] = settings
+	}
+
+	Config.Profiles[name] = profile
+	Config.SavedUIState.ActiveProfile = name
+	log.Printf("Saved profile %s with outputs %v", name, slices.Sorted(maps.Keys(profile.Outputs)))
+	return nil
+}
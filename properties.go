@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ProjectPropertyOption struct {
+	Label string `json:"label"`
+	Value any    `json:"value"`
+}
+
+type ProjectProperty struct {
+	Name    string                  `json:"-"`
+	Text    string                  `json:"text"`
+	Type    string                  `json:"type"`
+	Order   float64                 `json:"order"`
+	Value   any                     `json:"value"`
+	Min     float64                 `json:"min"`
+	Max     float64                 `json:"max"`
+	Step    float64                 `json:"step"`
+	Options []ProjectPropertyOption `json:"options"`
+}
+
+// The user property types that can be edited from the UI and passed to linux-wallpaperengine.
+var EditablePropertyTypes = []string{"slider", "bool", "color", "combo", "textinput"}
+
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// Parses the user properties declared under general.properties in the given project.json contents.
+//
+// Properties are parsed one by one, so a property that fails to parse is skipped instead of hiding all the others.
+// Returns the properties sorted by their order, then by name.
+func parseProjectProperties(data []byte) []ProjectProperty {
+	var project struct {
+		General struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"general"`
+	}
+	if err := json.Unmarshal(data, &project); err != nil {
+		log.Printf("Error reading general.properties from project.json: %v", err)
+		return []ProjectProperty{}
+	}
+
+	properties := []ProjectProperty{}
+	for name, rawProperty := range project.General.Properties {
+		property := ProjectProperty{}
+		if err := json.Unmarshal(rawProperty, &property); err != nil {
+			log.Printf("Skipping property %s, failed to parse it: %v", name, err)
+			continue
+		}
+		property.Name = name
+		properties = append(properties, property)
+	}
+
+	slices.SortFunc(properties, func(a, b ProjectProperty) int {
+		if a.Order != b.Order {
+			if a.Order < b.Order {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return properties
+}
+
+// Formats a property value from project.json the way linux-wallpaperengine's --set-property expects it.
+func formatPropertyValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Returns the current value of the property for the given wallpaper.
+// This is the override saved in Config.Wallpapers if there is one, the default value from project.json otherwise.
+func propertyValue(wallpaperId string, property ProjectProperty) string {
+	if value, ok := Config.Wallpapers[wallpaperId].Properties[property.Name]; ok {
+		return value
+	}
+	return formatPropertyValue(property.Value)
+}
+
+// Returns the label to show for the property.
+//
+// Wallpaper Engine uses HTML in some labels, and translation keys (e.g. ui_browse_properties_scheme_color) in others,
+// so the HTML is stripped and the property name is used instead of translation keys.
+func propertyLabel(property ProjectProperty) string {
+	label := strings.TrimSpace(htmlTagRegex.ReplaceAllString(property.Text, ""))
+	if label == "" || strings.HasPrefix(label, "ui_") {
+		return property.Name
+	}
+	return label
+}
+
+// Parses a color property value ("r g b" with components from 0 to 1) into its components.
+// Some wallpapers use components from 0 to 255, which are scaled down to 0 to 1.
+func parseColorValue(value string) (float32, float32, float32) {
+	components := [3]float32{}
+	fields := strings.Fields(value)
+	scale := float32(1)
+	for i := 0; i < len(fields) && i < 3; i++ {
+		component, err := strconv.ParseFloat(fields[i], 32)
+		if err != nil {
+			continue
+		}
+		components[i] = float32(component)
+		if component > 1 {
+			scale = 255
+		}
+	}
+	return components[0] / scale, components[1] / scale, components[2] / scale
+}
+
+// Formats color components from 0 to 1 as a color property value ("r g b").
+func formatColorValue(red, green, blue float32) string {
+	return strconv.FormatFloat(float64(red), 'f', -1, 32) + " " +
+		strconv.FormatFloat(float64(green), 'f', -1, 32) + " " +
+		strconv.FormatFloat(float64(blue), 'f', -1, 32)
+}
+
+// Sets (or with an empty name, resets all) property overrides of the given wallpaper in Config.Wallpapers.
+func setPropertyOverride(wallpaperId string, name string, value string) {
+	updateWallpaperSettings(wallpaperId, func(settings *WallpaperSettingsStruct) {
+		if name == "" {
+			settings.Properties = nil
+			return
+		}
+		if settings.Properties == nil {
+			settings.Properties = map[string]string{}
+		}
+		settings.Properties[name] = value
+	})
+}
+
+// Returns the --set-property arguments for the property overrides of the given wallpaper, sorted by property name.
+func propertyArguments(wallpaperId string) []string {
+	properties := Config.Wallpapers[wallpaperId].Properties
+
+	arguments := []string{}
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		arguments = append(arguments, "--set-property", name+"="+properties[name])
+	}
+	return arguments
+}
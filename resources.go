@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The kernel's USER_HZ, the unit of the CPU times in /proc/<pid>/stat; 100 on every common architecture.
+const ClockTicksPerSecond = 100
+
+// How many resource samples to take between saves of the wallpaper stats file.
+var WallpaperStatsSaveEvery = 30
+
+type ProcessResources struct {
+	CPUTicks uint64 // user + system CPU time in clock ticks
+	RSS      int64  // resident memory in bytes
+	Threads  int
+}
+
+type WallpaperStats struct {
+	Samples    int64   `json:"samples"`
+	AverageCPU float64 `json:"average_cpu"` // in percent of one core
+	AverageRSS float64 `json:"average_rss"` // in bytes
+}
+
+// The average resource usage per wallpaper ID, recorded while the wallpapers run, see watchEngineResources.
+var WallpaperStatsMap map[string]WallpaperStats = map[string]WallpaperStats{}
+var wallpaperStatsMutex sync.Mutex
+
+// Reads the CPU time from /proc/<pid>/stat, and the resident memory and thread count from /proc/<pid>/status.
+func readProcessResources(pid int) (ProcessResources, error) {
+	resources := ProcessResources{}
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return resources, err
+	}
+	// the command name (2nd field) can contain spaces, so split after the last ")"
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	// utime and stime are the 14th and 15th fields, and the fields after the parentheses start at the 3rd
+	if len(fields) < 13 {
+		return resources, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	resources.CPUTicks = utime + stime
+
+	status, err := os.ReadFile(filepath.Join(procDir, "status"))
+	if err != nil {
+		return resources, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		valueFields := strings.Fields(value)
+		if len(valueFields) == 0 {
+			continue
+		}
+		switch key {
+		case "VmRSS":
+			// reported in kB
+			rss, _ := strconv.ParseInt(valueFields[0], 10, 64)
+			resources.RSS = rss * 1024
+		case "Threads":
+			resources.Threads, _ = strconv.Atoi(valueFields[0])
+		}
+	}
+	return resources, nil
+}
+
+// Returns the path to the wallpaper stats file in the cache directory.
+func wallpaperStatsFile() string {
+	return path.Join(CacheDir, "stats.json")
+}
+
+// Loads WallpaperStatsMap from the wallpaper stats file. A missing file means no stats are recorded yet.
+func loadWallpaperStats() error {
+	content, err := os.ReadFile(wallpaperStatsFile())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read wallpaper stats: %v", err)
+	}
+
+	stats := map[string]WallpaperStats{}
+	if err := json.Unmarshal(content, &stats); err != nil {
+		return fmt.Errorf("failed to unmarshal wallpaper stats: %v", err)
+	}
+
+	wallpaperStatsMutex.Lock()
+	defer wallpaperStatsMutex.Unlock()
+	WallpaperStatsMap = stats
+	return nil
+}
+
+// Saves WallpaperStatsMap to the wallpaper stats file.
+func saveWallpaperStats() error {
+	wallpaperStatsMutex.Lock()
+	content, err := json.MarshalIndent(WallpaperStatsMap, "", "  ")
+	wallpaperStatsMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal wallpaper stats: %v", err)
+	}
+
+	if err := os.WriteFile(wallpaperStatsFile(), content, 0644); err != nil {
+		return fmt.Errorf("failed to write wallpaper stats: %v", err)
+	}
+	return nil
+}
+
+// Adds a sample of the resource usage of the wallpaper to its running averages in WallpaperStatsMap.
+func recordWallpaperSample(wallpaperId string, cpu float64, rss int64) {
+	wallpaperStatsMutex.Lock()
+	defer wallpaperStatsMutex.Unlock()
+
+	stats := WallpaperStatsMap[wallpaperId]
+	stats.Samples++
+	stats.AverageCPU += (cpu - stats.AverageCPU) / float64(stats.Samples)
+	stats.AverageRSS += (float64(rss) - stats.AverageRSS) / float64(stats.Samples)
+	WallpaperStatsMap[wallpaperId] = stats
+}
+
+// Returns the recorded stats of the wallpaper, and whether there are any.
+func wallpaperStats(wallpaperId string) (WallpaperStats, bool) {
+	wallpaperStatsMutex.Lock()
+	defer wallpaperStatsMutex.Unlock()
+
+	stats, ok := WallpaperStatsMap[wallpaperId]
+	return stats, ok && stats.Samples > 0
+}
+
+// Formats a number of bytes as megabytes, e.g. "256 MB".
+func formatMegabytes(bytes float64) string {
+	return strconv.FormatFloat(bytes/1024/1024, 'f', 0, 64) + " MB"
+}
+
+// Samples the resource usage of the running engines every interval until ctx is cancelled.
+// Expects WallpaperStatsMap to be loaded with loadWallpaperStats beforehand.
+//
+// The total usage is shown with updateGUIResourceText. With recordStats, every sample is also added to the averages of its wallpaper
+// in WallpaperStatsMap, which is saved every WallpaperStatsSaveEvery samples; only one process should record them.
+// Paused engines are not sampled, as they would lower the averages.
+//
+// Meant to be run as a goroutine.
+func watchEngineResources(ctx context.Context, interval time.Duration, recordStats bool) {
+	if recordStats {
+		defer func() {
+			if err := saveWallpaperStats(); err != nil {
+				log.Printf("Failed to save wallpaper stats: %v", err)
+			}
+		}()
+	}
+
+	// the CPU usage is the difference in CPU time between two samples, keyed by PID and start time
+	lastTicks := map[string]uint64{}
+	lastSample := time.Now()
+	samples := 0
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		processes, err := runningTrackedProcesses()
+		if err != nil {
+			log.Printf("Failed to read tracked processes: %v", err)
+			continue
+		}
+
+		elapsed := time.Since(lastSample).Seconds()
+		lastSample = time.Now()
+		currentTicks := map[string]uint64{}
+		totalCPU := 0.0
+		totalRSS := int64(0)
+		totalThreads := 0
+		for _, process := range processes {
+			if process.Paused {
+				continue
+			}
+			resources, err := readProcessResources(process.PID)
+			if err != nil {
+				continue
+			}
+
+			key := fmt.Sprintf("%d-%d", process.PID, process.StartTime)
+			currentTicks[key] = resources.CPUTicks
+			previousTicks, sampled := lastTicks[key]
+			if !sampled || elapsed <= 0 {
+				// a CPU percentage needs two samples
+				continue
+			}
+
+			cpu := float64(resources.CPUTicks-previousTicks) / ClockTicksPerSecond / elapsed * 100
+			totalCPU += cpu
+			totalRSS += resources.RSS
+			totalThreads += resources.Threads
+			if recordStats {
+				recordWallpaperSample(process.WallpaperId, cpu, resources.RSS)
+			}
+		}
+		lastTicks = currentTicks
+
+		if len(currentTicks) == 0 {
+			updateGUIResourceText("")
+		} else {
+			updateGUIResourceText(fmt.Sprintf("CPU %.0f%% · RAM %s · %d threads", totalCPU, formatMegabytes(float64(totalRSS)), totalThreads))
+		}
+
+		samples++
+		if recordStats && samples%WallpaperStatsSaveEvery == 0 {
+			if err := saveWallpaperStats(); err != nil {
+				log.Printf("Failed to save wallpaper stats: %v", err)
+			}
+		}
+	}
+}
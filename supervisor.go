@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The delay before the first restart of a crashed engine, doubled after every crash in the crash window.
+var SupervisorInitialBackoff = 1 * time.Second
+
+// The maximum delay between restarts of a crashed engine.
+var SupervisorMaxBackoff = 30 * time.Second
+
+// An engine process started by startEngine, restarted by the supervisor when it crashes.
+type engineSupervision struct {
+	output      string
+	wallpaperId string
+	command     []string
+	stopped     bool // set by stopSupervisingEngines, when the engine is stopped on purpose
+}
+
+var supervisedEngines []*engineSupervision = []*engineSupervision{}
+
+// Guards supervisedEngines and the stopped flags, and is held while (re)starting engines,
+// so an engine is never started after stopSupervisingEngines returned.
+var supervisedEnginesMutex sync.Mutex
+
+// Starts linux-wallpaperengine with the given command on the output, tracks it with trackProcess,
+// and supervises it if Config.Supervisor.Enabled is true.
+//
+// Returns the PID of the started process, or an error if it could not be started.
+func startEngine(command []string, output string, wallpaperId string) (int, error) {
+	supervisedEnginesMutex.Lock()
+	defer supervisedEnginesMutex.Unlock()
+
+	return startEngineLocked(command, output, wallpaperId)
+}
+
+// Same as startEngine, but expects supervisedEnginesMutex to be held by the caller.
+func startEngineLocked(command []string, output string, wallpaperId string) (int, error) {
+	cmd, logPath, err := startLoggedEngine(command, output, wallpaperId)
+	if err != nil {
+		return -1, err
+	}
+	if err := trackProcess(cmd.Process.Pid, output, wallpaperId); err != nil {
+		log.Printf("Failed to track the wallpaper process for output %s: %v", output, err)
+	}
+
+	supervision := &engineSupervision{
+		output:      output,
+		wallpaperId: wallpaperId,
+		command:     command,
+	}
+	supervisedEngines = append(supervisedEngines, supervision)
+	go superviseEngine(supervision, cmd, logPath)
+
+	return cmd.Process.Pid, nil
+}
+
+// Stops supervising every engine, so they are not restarted when they are killed on purpose, e.g. to apply another wallpaper.
+func stopSupervisingEngines() {
+	supervisedEnginesMutex.Lock()
+	defer supervisedEnginesMutex.Unlock()
+
+	for _, supervision := range supervisedEngines {
+		supervision.stopped = true
+	}
+	supervisedEngines = []*engineSupervision{}
+}
+
+// Returns whether the exited process crashed, instead of exiting normally or being stopped with a signal like SIGTERM.
+func isEngineCrash(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() {
+		return !slices.Contains([]syscall.Signal{syscall.SIGTERM, syscall.SIGKILL, syscall.SIGINT, syscall.SIGHUP}, status.Signal())
+	}
+	return state.ExitCode() != 0
+}
+
+// Waits for the engine to exit, and restarts it with exponential backoff if it crashed.
+// Every run is also checked for signs of a broken wallpaper, see watchEngineLogForFailures, and the run started by the apply
+// for exiting within the detection window, see detectEarlyExit. Later crashes do not mark the wallpaper as broken,
+// as engines also crash when e.g. the compositor restarts; only giving up on them does.
+//
+// After Config.Supervisor.MaxCrashes crashes within Config.Supervisor.CrashWindow seconds, it gives up, see giveUpOnEngine.
+// Without Config.Supervisor.Enabled, it only waits for the process, so it does not linger as a zombie.
+func superviseEngine(supervision *engineSupervision, cmd *exec.Cmd, logPath string) {
+	crashes := []time.Time{}
+	appliedAt := time.Now()
+	firstRun := true
+	for {
+		go watchEngineLogForFailures(supervision.wallpaperId, logPath, cmd.Process.Pid)
+		cmd.Wait()
+
+		supervisedEnginesMutex.Lock()
+		stopped := supervision.stopped
+		supervisedEnginesMutex.Unlock()
+		if stopped {
+			return
+		}
+		if firstRun {
+			if reason, exited := detectEarlyExit(cmd.ProcessState, time.Since(appliedAt)); exited {
+				markWallpaperBroken(supervision.wallpaperId, reason)
+			}
+			firstRun = false
+		}
+		if !isEngineCrash(cmd.ProcessState) {
+			log.Printf("linux-wallpaperengine on output %s exited (%v)", supervision.output, cmd.ProcessState)
+			return
+		}
+		log.Printf("linux-wallpaperengine on output %s crashed (%v)", supervision.output, cmd.ProcessState)
+		if !Config.Supervisor.Enabled {
+			return
+		}
+
+		now := time.Now()
+		window := time.Duration(Config.Supervisor.CrashWindow) * time.Second
+		crashes = slices.DeleteFunc(crashes, func(crash time.Time) bool { return now.Sub(crash) > window })
+		crashes = append(crashes, now)
+		if int64(len(crashes)) >= Config.Supervisor.MaxCrashes {
+			giveUpOnEngine(supervision, len(crashes))
+			return
+		}
+
+		backoff := min(SupervisorInitialBackoff<<(len(crashes)-1), SupervisorMaxBackoff)
+		updateGUIStatusText(fmt.Sprintf("linux-wallpaperengine crashed on %s, restarting in %v...", supervision.output, backoff))
+		time.Sleep(backoff)
+
+		supervisedEnginesMutex.Lock()
+		if supervision.stopped {
+			supervisedEnginesMutex.Unlock()
+			return
+		}
+		restarted, restartedLogPath, err := startLoggedEngine(supervision.command, supervision.output, supervision.wallpaperId)
+		if err == nil {
+			if err := trackProcess(restarted.Process.Pid, supervision.output, supervision.wallpaperId); err != nil {
+				log.Printf("Failed to track the wallpaper process for output %s: %v", supervision.output, err)
+			}
+		}
+		supervisedEnginesMutex.Unlock()
+
+		if err != nil {
+			log.Printf("Failed to restart linux-wallpaperengine on output %s: %v", supervision.output, err)
+			updateGUIStatusText("Failed to restart linux-wallpaperengine on " + supervision.output)
+			return
+		}
+		log.Printf("Restarted linux-wallpaperengine on output %s (PID: %d)", supervision.output, restarted.Process.Pid)
+		updateGUIStatusText("Restarted linux-wallpaperengine on " + supervision.output)
+		cmd = restarted
+		logPath = restartedLogPath
+	}
+}
+
+// Stops restarting the crashing engine, marks its wallpaper as broken,
+// and applies Config.Supervisor.SafeWallpaperId on its output instead, if one is set.
+func giveUpOnEngine(supervision *engineSupervision, crashes int) {
+	log.Printf("Wallpaper %s crashed %d times on output %s, giving up", supervision.wallpaperId, crashes, supervision.output)
+	markWallpaperBroken(supervision.wallpaperId, fmt.Sprintf("linux-wallpaperengine crashed %d times within %d seconds", crashes, Config.Supervisor.CrashWindow))
+
+	safeWallpaperId := Config.Supervisor.SafeWallpaperId
+	if safeWallpaperId == "" || safeWallpaperId == supervision.wallpaperId {
+		updateGUIStatusText(fmt.Sprintf("Wallpaper %s kept crashing on %s and was marked as broken", supervision.wallpaperId, supervision.output))
+		return
+	}
+
+	safeWallpaperPath, err := resolvePath(path.Join(Config.Constants.WallpaperEngineDir, safeWallpaperId))
+	if err != nil {
+		log.Printf("Failed to resolve the safe wallpaper path: %v", err)
+		return
+	}
+	cmd, _ := createWallpaperCommand(supervision.output, safeWallpaperPath, float64(Config.SavedUIState.Volume), "", false)
+
+	supervisedEnginesMutex.Lock()
+	if supervision.stopped {
+		supervisedEnginesMutex.Unlock()
+		return
+	}
+	_, err = startEngineLocked(cmd, supervision.output, safeWallpaperId)
+	supervisedEnginesMutex.Unlock()
+	if err != nil {
+		log.Printf("Failed to start the safe wallpaper on output %s: %v", supervision.output, err)
+		return
+	}
+
+	// so restoring does not start the crashing wallpaper again
+	runStateUpdate(func() {
+		err := updateConfigFile(func(config *ConfigStruct) {
+			if config.SavedUIState.LastSetIds == nil {
+				config.SavedUIState.LastSetIds = map[string]string{}
+			}
+			config.SavedUIState.LastSetIds[supervision.output] = safeWallpaperId
+		})
+		if err != nil {
+			log.Printf("Failed to save the safe wallpaper of output %s: %v", supervision.output, err)
+		}
+	})
+	updateGUIStatusText(fmt.Sprintf("Wallpaper %s kept crashing on %s, switched to the safe wallpaper", supervision.wallpaperId, supervision.output))
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"log"
+	"maps"
 	"math/rand"
 	"os"
 	"path"
+	"reflect"
 	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/image/bmp"
@@ -31,173 +38,527 @@ type WallpaperItem struct {
 type ProjectJSON struct {
 	Title        string   `json:"title"`
 	Description  string   `json:"description"`
+	Type         string   `json:"type"` // e.g. "scene", "video" or "web"
 	Tags         []string `json:"tags"`
 	PreviewImage string   `json:"preview"`
+
+	// parsed separately with parseProjectProperties, as they are not as consistent as the fields above
+	Properties []ProjectProperty `json:"-"`
 }
 
 var WallpaperItems []WallpaperItem = []WallpaperItem{}
-var settingWallpaper bool = false
 
-// Creates the command string to run linux-wallpaperengine with the given wallpaper path and volume.
-// Also returns the path to the screenshot file that will be created by the command as the second return value.
-func createWallpaperCommand(wallpaperPath string, volume float64) (string, string) {
-	cmd := Config.Constants.LinuxWallpaperEngineBin + " --screen-root HDMI-A-1 --bg " + wallpaperPath
+// The criteria the wallpapers can be sorted by, see sortWallpapers.
+var SortModes = []string{"date_desc", "date_asc", "name_asc", "name_desc", "cpu_desc", "cpu_asc"}
 
-	if volume <= 1 {
-		cmd += " --silent"
+// The scaling modes supported by linux-wallpaperengine's --scaling flag; "default" does not pass the flag.
+var ScalingModes = []string{"default", "stretch", "fit", "fill"}
+
+// The clamping modes supported by linux-wallpaperengine's --clamping flag; "default" does not pass the flag.
+var ClampingModes = []string{"default", "clamp", "border", "repeat"}
+
+// Returns the scaling mode to run the given wallpaper with.
+//
+// Uses the first one that is set of: the override (e.g. from a profile), the wallpaper's setting in Config.Wallpapers, and Config.SavedUIState.Scaling.
+func resolveScaling(wallpaperId string, override string) string {
+	if override != "" {
+		return override
+	}
+	if scaling := Config.Wallpapers[wallpaperId].Scaling; scaling != "" {
+		return scaling
+	}
+	return Config.SavedUIState.Scaling
+}
+
+// Returns the clamping mode to run the given wallpaper with.
+//
+// Uses the wallpaper's setting in Config.Wallpapers if set, Config.SavedUIState.Clamping otherwise.
+func resolveClamping(wallpaperId string) string {
+	if clamping := Config.Wallpapers[wallpaperId].Clamping; clamping != "" {
+		return clamping
+	}
+	return Config.SavedUIState.Clamping
+}
+
+// Returns the engine settings to run the given wallpaper with.
+//
+// Starts from Config.Engine, and replaces every setting that the wallpaper overrides in Config.Wallpapers.
+func resolveEngineSettings(wallpaperId string) EngineStruct {
+	engine := Config.Engine
+	settings := Config.Wallpapers[wallpaperId]
+
+	if settings.FPS != nil {
+		engine.FPS = *settings.FPS
+	}
+	if settings.NoFullscreenPause != nil {
+		engine.NoFullscreenPause = *settings.NoFullscreenPause
+	}
+	if settings.DisableMouse != nil {
+		engine.DisableMouse = *settings.DisableMouse
+	}
+	if settings.NoAutomute != nil {
+		engine.NoAutomute = *settings.NoAutomute
+	}
+	if settings.NoAudioProcessing != nil {
+		engine.NoAudioProcessing = *settings.NoAudioProcessing
+	}
+	return engine
+}
+
+// Marks the wallpaper as broken in Config.SavedUIState.Broken (see setBroken) and WallpaperItems, and refreshes the GUI if it is running.
+// The reason is saved in Config.SavedUIState.BrokenReasons, to show why the wallpaper was marked as broken.
+func markWallpaperBroken(wallpaperId string, reason string) {
+	// called by the supervisor and the log watcher, see runStateUpdate
+	runStateUpdate(func() {
+		if err := setBroken(wallpaperId, true, reason); err != nil {
+			log.Printf("Failed to save wallpaper %s as broken: %v", wallpaperId, err)
+		}
+		refreshWallpaperMarks()
+	})
+}
+
+// Updates the settings of the given wallpaper in Config.Wallpapers using the update function.
+// Removes the wallpaper from Config.Wallpapers if all of its settings are unset afterwards, to keep the config tidy.
+func updateWallpaperSettings(wallpaperId string, update func(settings *WallpaperSettingsStruct)) {
+	settings := Config.Wallpapers[wallpaperId]
+	update(&settings)
+
+	if reflect.ValueOf(settings).IsZero() {
+		delete(Config.Wallpapers, wallpaperId)
 	} else {
-		cmd += " --volume " + strconv.FormatFloat(volume, 'f', 0, 64)
+		Config.Wallpapers[wallpaperId] = settings
+	}
+}
+
+// Creates the command to run linux-wallpaperengine on the given output with the given wallpaper path and volume.
+// The command is returned as the binary followed by its arguments, to run it without a shell, see formatCommand for a printable version.
+// The scaling mode overrides the wallpaper's scaling mode if not empty, see resolveScaling.
+//
+// Options that the binary does not support (see engineSupports) are left out, as linux-wallpaperengine exits on unknown options.
+//
+// If screenshot is true, the command also saves a screenshot of the wallpaper, for post-processing.
+// The path to that screenshot file is returned as the second return value, or an empty string if no screenshot is taken.
+func createWallpaperCommand(output string, wallpaperPath string, volume float64, scaling string, screenshot bool) ([]string, string) {
+	cmd := []string{engineBinary(), "--screen-root", output, "--bg", wallpaperPath}
+
+	volume = powerPolicyVolume(volume)
+	if volume <= 1 {
+		if useEngineOption("--silent") {
+			cmd = append(cmd, "--silent")
+		}
+	} else if useEngineOption("--volume") {
+		cmd = append(cmd, "--volume", strconv.FormatFloat(volume, 'f', 0, 64))
+	}
+
+	wallpaperId := path.Base(wallpaperPath)
+	if scaling := resolveScaling(wallpaperId, scaling); scaling != "default" && useEngineOption("--scaling") {
+		cmd = append(cmd, "--scaling", scaling)
+	}
+	if clamping := resolveClamping(wallpaperId); clamping != "default" && useEngineOption("--clamping") {
+		cmd = append(cmd, "--clamping", clamping)
+	}
+
+	engine := resolveEngineSettings(wallpaperId)
+	engine.FPS = powerPolicyFPS(engine.FPS)
+	if engine.FPS > 0 && useEngineOption("--fps") {
+		cmd = append(cmd, "--fps", strconv.FormatInt(engine.FPS, 10))
+	}
+	if engine.NoFullscreenPause && useEngineOption("--no-fullscreen-pause") {
+		cmd = append(cmd, "--no-fullscreen-pause")
+	}
+	if engine.DisableMouse && useEngineOption("--disable-mouse") {
+		cmd = append(cmd, "--disable-mouse")
+	}
+	if engine.NoAutomute && useEngineOption("--noautomute") {
+		cmd = append(cmd, "--noautomute")
+	}
+	if engine.NoAudioProcessing && useEngineOption("--no-audio-processing") {
+		cmd = append(cmd, "--no-audio-processing")
+	}
+
+	if propertyArgs := propertyArguments(wallpaperId); len(propertyArgs) > 0 && useEngineOption("--set-property") {
+		cmd = append(cmd, propertyArgs...)
 	}
 
 	cacheScreenshot := ""
-	if Config.PostProcessing.Enabled {
+	if screenshot && useEngineOption("--screenshot") {
 		cacheScreenshot = path.Join(CacheDir, "screenshot.png")
 
-		cmd += " --screenshot " + cacheScreenshot
+		cmd = append(cmd, "--screenshot", cacheScreenshot)
 	}
 
-	if Config.Constants.WallpaperEngineAssets != "" {
-		cmd += " --assets-dir " + Config.Constants.WallpaperEngineAssets
+	if Config.Constants.WallpaperEngineAssets != "" && useEngineOption("--assets-dir") {
+		// the command is run without a shell, so ~/ has to be expanded here
+		assetsDir, err := resolvePath(Config.Constants.WallpaperEngineAssets)
+		if err != nil {
+			log.Printf("Failed to resolve the assets directory %s: %v", Config.Constants.WallpaperEngineAssets, err)
+			assetsDir = Config.Constants.WallpaperEngineAssets
+		}
+		cmd = append(cmd, "--assets-dir", assetsDir)
 	}
 
 	return cmd, cacheScreenshot
 }
 
-// Applies the wallpaper from the given wallpaperPath, with the specified volume.
+// Returns the outputs that wallpapers applied from the UI are set on.
+//
+// If Config.SavedUIState.TargetOutput is empty, or the output is no longer configured, every configured output is returned.
+func targetOutputs() []string {
+	if slices.Contains(Config.Constants.Outputs, Config.SavedUIState.TargetOutput) {
+		return []string{Config.SavedUIState.TargetOutput}
+	}
+	return Config.Constants.Outputs
+}
+
+// Returns the outputs configured in the given config that are currently connected.
+//
+// If none of the configured outputs are detected, e.g. because detection failed or the names do not match,
+// every configured output is returned, so detection problems never prevent applying wallpapers.
+func activeOutputs(config *ConfigStruct) []string {
+	monitors, err := listMonitors()
+	if err != nil {
+		log.Printf("Failed to detect connected outputs, using all configured outputs: %v", err)
+		return config.Constants.Outputs
+	}
+
+	connected := []string{}
+	for _, output := range config.Constants.Outputs {
+		if slices.ContainsFunc(monitors, func(monitor MonitorInfo) bool { return monitor.Name == output }) {
+			connected = append(connected, output)
+		} else {
+			log.Printf("Configured output %s is not connected", output)
+		}
+	}
+
+	if len(connected) == 0 {
+		return config.Constants.Outputs
+	}
+	return connected
+}
+
+// Applies the wallpaper from the given wallpaperPath to the given outputs, with the specified volume.
+// If no outputs are given, the wallpaper is applied to every configured output.
+//
+// If a profile matches the connected outputs (see connectedProfile), the wallpaper is applied to the outputs of that profile instead,
+// and the profile is updated with the new wallpaper and volume.
+//
+// The other outputs are restarted with their last set wallpaper, see applyAssignments.
 //
 // Returns nil if the wallpaper was successfully applied, an error otherwise.
-func applyWallpaper(wallpaperPath string, volume float64) error {
-	if settingWallpaper {
-		return fmt.Errorf("another wallpaper is currently being set. Please wait before setting another wallpaper")
+// Meant to be run through queueApply, which cancels ctx when another wallpaper is applied.
+func applyWallpaper(ctx context.Context, wallpaperPath string, volume float64, outputs ...string) error {
+	wallpaperId := path.Base(wallpaperPath)
+	config := applyConfig(ctx)
+
+	if profileName, profile, ok := connectedProfile(config); ok {
+		profileOutputs := slices.Sorted(maps.Keys(profile.Outputs))
+		outputs = slices.DeleteFunc(slices.Clone(outputs), func(output string) bool {
+			return !slices.Contains(profileOutputs, output)
+		})
+		if len(outputs) == 0 {
+			outputs = profileOutputs
+		}
+
+		// only save the changes to the profile if it was applied successfully
+		profile.Outputs = maps.Clone(profile.Outputs)
+		for _, output := range outputs {
+			settings := profile.Outputs[output]
+			settings.WallpaperId = wallpaperId
+			settings.Volume = int64(volume)
+			profile.Outputs[output] = settings
+		}
+
+		return applyProfile(ctx, profileName, profile, outputs[0])
+	}
+
+	if len(outputs) == 0 {
+		outputs = config.Constants.Outputs
+	}
+	if len(outputs) == 0 {
+		return fmt.Errorf("no outputs configured to apply the wallpaper to")
+	}
+
+	assignments := map[string]ProfileOutputStruct{}
+	for output, lastSetId := range config.SavedUIState.LastSetIds {
+		assignments[output] = ProfileOutputStruct{WallpaperId: lastSetId, Volume: int64(volume)}
+	}
+	for _, output := range outputs {
+		assignments[output] = ProfileOutputStruct{WallpaperId: wallpaperId, Volume: int64(volume)}
+	}
+
+	if err := applyAssignments(ctx, activeOutputs(config), assignments, outputs[0]); err != nil {
+		return err
+	}
+	runStateUpdate(func() {
+		Config.SavedUIState.ActiveProfile = ""
+	})
+	return nil
+}
+
+// Applies the given profile, starting every output in it with its assigned wallpaper, volume, and scaling.
+// If primaryOutput is empty, the first output (by name) with a wallpaper is used for post-processing.
+//
+// On success, the profile is saved to Config.Profiles and set as Config.SavedUIState.ActiveProfile, see runStateUpdate.
+func applyProfile(ctx context.Context, profileName string, profile ProfileStruct, primaryOutput string) error {
+	outputs := slices.Sorted(maps.Keys(profile.Outputs))
+	if primaryOutput == "" {
+		for _, output := range outputs {
+			if profile.Outputs[output].WallpaperId != "" {
+				primaryOutput = output
+				break
+			}
+		}
+	}
+	if primaryOutput == "" {
+		return fmt.Errorf("profile %s has no wallpaper assigned to any output", profileName)
 	}
+
+	log.Printf("Applying profile %s", profileName)
+	if err := applyAssignments(ctx, outputs, profile.Outputs, primaryOutput); err != nil {
+		return err
+	}
+
+	runStateUpdate(func() {
+		if Config.Profiles == nil {
+			Config.Profiles = map[string]ProfileStruct{}
+		}
+		Config.Profiles[profileName] = profile
+		Config.SavedUIState.ActiveProfile = profileName
+	})
+	return nil
+}
+
+// Starts a linux-wallpaperengine process for each of the given outputs, with the wallpaper, volume, and scaling assigned to that output.
+// Any linux-wallpaperengine processes started by the helper are killed first. Outputs without an assigned wallpaper are skipped.
+// While the "fallback" power policy is active, the fallback wallpaper is shown instead, but the assigned IDs are still saved.
+//
+// Post-processing only runs for the primaryOutput, as there is only one screenshot and post command.
+// If ctx is cancelled before the wallpapers are started, nothing is started; if it is cancelled afterwards, post-processing is stopped.
+//
+// Returns nil if the wallpapers were successfully applied, an error otherwise.
+// On success, the wallpaper IDs of the started outputs are saved to Config.SavedUIState.LastSetIds, see runStateUpdate.
+// Everything else is read from the config snapshot of ctx, see applyConfig.
+func applyAssignments(ctx context.Context, outputs []string, assignments map[string]ProfileOutputStruct, primaryOutput string) error {
 	updateGUIStatusText("Starting linux-wallpaperengine...")
-	settingWallpaper = true
+	config := applyConfig(ctx)
+	postProcessing := postProcessingSettings(ctx)
 
 	defer func() {
 		updateGUIStatusText("Double-click a wallpaper to apply it.")
-		settingWallpaper = false
+		updateGUIPauseState()
 	}()
 
-	cmd, cacheScreenshot := createWallpaperCommand(wallpaperPath, volume)
-
-	err := tryKillProcesses("linux-wallpaperengine")
+	stopSupervisingEngines()
+	err := tryKillTrackedProcesses()
 	if err != nil {
 		return fmt.Errorf("error trying to kill existing processes: %v", err)
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	log.Println("Executing command:", cmd)
-	pid, err := runDetachedProcess("sh", "-c", cmd)
-	if err != nil {
-		// exit if we cannot start the command, to prevent multiple instances taking up resources
-		return fmt.Errorf("error starting wallpaper command '%s': %v", cmd, err)
-	} else {
-		log.Printf("Successfully started detached wallpaper command (PID: %d): %s", pid, cmd)
+	startedOutputs := []string{}
+	primaryPid := -1
+	primaryWallpaperPath := ""
+	cacheScreenshot := ""
+	for _, output := range outputs {
+		settings := assignments[output]
+		if settings.WallpaperId == "" {
+			log.Printf("No wallpaper assigned to output %s, skipping", output)
+			continue
+		}
+		if fallbackWallpaperId := powerPolicyWallpaper(); fallbackWallpaperId != "" {
+			log.Printf("On battery, showing fallback wallpaper %s instead of %s on output %s", fallbackWallpaperId, settings.WallpaperId, output)
+			settings.WallpaperId = fallbackWallpaperId
+		}
+
+		wallpaperPath, err := resolvePath(path.Join(config.Constants.WallpaperEngineDir, settings.WallpaperId))
+		if err != nil {
+			log.Printf("Failed to resolve wallpaper path for output %s: %v", output, err)
+			continue
+		}
+
+		cmd, screenshot := createWallpaperCommand(output, wallpaperPath, float64(settings.Volume), settings.Scaling, output == primaryOutput && postProcessing.Enabled)
+
+		log.Println("Executing command:", formatCommand(cmd))
+		pid, err := startEngine(cmd, output, settings.WallpaperId)
+		if err != nil {
+			// exit if we cannot start the command, to prevent multiple instances taking up resources
+			return fmt.Errorf("error starting wallpaper command '%s': %v", formatCommand(cmd), err)
+		} else {
+			log.Printf("Successfully started detached wallpaper command (PID: %d): %s", pid, formatCommand(cmd))
+		}
+		startedOutputs = append(startedOutputs, output)
+
+		if output == primaryOutput {
+			primaryPid = pid
+			primaryWallpaperPath = wallpaperPath
+			cacheScreenshot = screenshot
+		}
 	}
 
-	if Config.PostProcessing.Enabled {
-		log.Println("Post-processing enabled, running post-processing...")
+	if postProcessing.Enabled && primaryWallpaperPath != "" {
+		runPostProcessing(ctx, primaryOutput, primaryWallpaperPath, cacheScreenshot, float64(assignments[primaryOutput].Volume), primaryPid)
+	}
 
-		if Config.PostProcessing.ArtificialDelay > 0 {
-			updateGUIStatusText("Delaying post-processing...")
-			log.Printf("Waiting for %d seconds before running post-processing...", Config.PostProcessing.ArtificialDelay)
-			time.Sleep(time.Duration(Config.PostProcessing.ArtificialDelay) * time.Second)
+	// Save the last set wallpaper IDs, in Config instead of the snapshot
+	appliedAt := time.Now()
+	runStateUpdate(func() {
+		if Config.SavedUIState.LastSetIds == nil {
+			Config.SavedUIState.LastSetIds = map[string]string{}
+		}
+		if Config.SavedUIState.LastApplied == nil {
+			Config.SavedUIState.LastApplied = map[string]time.Time{}
 		}
-		updateGUIStatusText("Running post-processing...")
+		for _, output := range startedOutputs {
+			Config.SavedUIState.LastSetIds[output] = assignments[output].WallpaperId
+			Config.SavedUIState.LastApplied[assignments[output].WallpaperId] = appliedAt
+		}
+	})
+	return nil
+}
 
-		if len(Config.PostProcessing.ScreenshotFiles) > 0 && len(Config.PostProcessing.ScreenshotFiles[0]) > 0 {
-			for _, filePath := range Config.PostProcessing.ScreenshotFiles {
-				if path.Ext(filePath) == "" {
-					filePath += ".png" // ensure the file has a .png extension
-				}
+type postProcessingKey struct{}
 
-				if !slices.Contains([]string{".png", ".jpg", ".jpeg", ".bmp"}, path.Ext(filePath)) {
-					log.Printf("Unsupported file format for post-processing: %s", filePath)
-					continue // skip unsupported formats
-				}
+// Returns a copy of ctx that applies wallpapers with the given post-processing settings instead of Config.PostProcessing,
+// e.g. the overrides of a CLI command sent to the daemon. Without settings, ctx is returned unchanged.
+func withPostProcessing(ctx context.Context, settings *PostProcessingStruct) context.Context {
+	if settings == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, postProcessingKey{}, *settings)
+}
 
-				source, err := os.Open(cacheScreenshot)
-				if err != nil {
-					log.Printf("Error opening screenshot file for post-processing: %v", err)
-					continue
-				}
-				defer source.Close()
+// Returns the post-processing settings of ctx, see withPostProcessing, or those of its config snapshot if it has none.
+func postProcessingSettings(ctx context.Context) PostProcessingStruct {
+	if settings, ok := ctx.Value(postProcessingKey{}).(PostProcessingStruct); ok {
+		return settings
+	}
+	return applyConfig(ctx).PostProcessing
+}
 
-				dest, err := os.Create(filePath)
-				if err != nil {
-					log.Printf("Error creating destination file for post-processing: %v", err)
+// Runs the post-processing steps for a wallpaper that was just applied to the given output.
+//
+// This copies the screenshot to the configured screenshot files, runs the post command, and sets swww if enabled.
+// Stops early when ctx is cancelled, e.g. because another wallpaper is being applied.
+// Uses the post-processing settings of ctx, see postProcessingSettings.
+func runPostProcessing(ctx context.Context, output string, wallpaperPath string, cacheScreenshot string, volume float64, pid int) {
+	log.Println("Post-processing enabled, running post-processing...")
+	settings := postProcessingSettings(ctx)
+
+	if settings.ArtificialDelay > 0 {
+		updateGUIStatusText("Delaying post-processing...")
+		log.Printf("Waiting for %d seconds before running post-processing...", settings.ArtificialDelay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(settings.ArtificialDelay) * time.Second):
+		}
+	}
+	if ctx.Err() != nil {
+		log.Println("Post-processing cancelled, another wallpaper is being applied")
+		return
+	}
+	updateGUIStatusText("Running post-processing...")
+
+	if len(settings.ScreenshotFiles) > 0 && len(settings.ScreenshotFiles[0]) > 0 {
+		for _, filePath := range settings.ScreenshotFiles {
+			if ctx.Err() != nil {
+				log.Println("Post-processing cancelled, another wallpaper is being applied")
+				return
+			}
+			if path.Ext(filePath) == "" {
+				filePath += ".png" // ensure the file has a .png extension
+			}
+
+			if !slices.Contains([]string{".png", ".jpg", ".jpeg", ".bmp"}, path.Ext(filePath)) {
+				log.Printf("Unsupported file format for post-processing: %s", filePath)
+				continue // skip unsupported formats
+			}
+
+			source, err := os.Open(cacheScreenshot)
+			if err != nil {
+				log.Printf("Error opening screenshot file for post-processing: %v", err)
+				continue
+			}
+			defer source.Close()
+
+			dest, err := os.Create(filePath)
+			if err != nil {
+				log.Printf("Error creating destination file for post-processing: %v", err)
+				continue
+			}
+			defer dest.Close()
+
+			if path.Ext(filePath) == ".png" {
+				// no need for transcoding, just copy the file
+				log.Printf("Copying screenshot to: %s", filePath)
+
+				if _, err := io.Copy(dest, source); err != nil {
+					log.Printf("Error copying screenshot file: %v", err)
 					continue
 				}
-				defer dest.Close()
-
-				if path.Ext(filePath) == ".png" {
-					// no need for transcoding, just copy the file
-					log.Printf("Copying screenshot to: %s", filePath)
 
-					if _, err := io.Copy(dest, source); err != nil {
-						log.Printf("Error copying screenshot file: %v", err)
-						continue
-					}
+				log.Printf("Copied screenshot to: %s", filePath)
+				continue
+			} else {
+				// transcoding the screenshot to the specified file format
+				log.Printf("Transcoding screenshot to: %s", filePath)
 
-					log.Printf("Copied screenshot to: %s", filePath)
+				img, err := png.Decode(source)
+				if err != nil {
+					log.Printf("Error decoding PNG for transcoding: %v", err)
 					continue
-				} else {
-					// transcoding the screenshot to the specified file format
-					log.Printf("Transcoding screenshot to: %s", filePath)
+				}
 
-					img, err := png.Decode(source)
+				switch ext := path.Ext(filePath); ext {
+				case ".jpg", ".jpeg":
+					err = jpeg.Encode(dest, img, nil)
 					if err != nil {
-						log.Printf("Error decoding PNG for transcoding: %v", err)
-						continue
+						log.Printf("Error encoding JPEG for transcoding: %v", err)
 					}
-
-					switch ext := path.Ext(filePath); ext {
-					case ".jpg", ".jpeg":
-						err = jpeg.Encode(dest, img, nil)
-						if err != nil {
-							log.Printf("Error encoding JPEG for transcoding: %v", err)
-						}
-						log.Printf("Successfully transcoded screenshot to: %s", filePath)
-					case ".bmp":
-						err = bmp.Encode(dest, img)
-						if err != nil {
-							log.Printf("Error encoding BMP for transcoding: %v", err)
-						}
-						log.Printf("Successfully transcoded screenshot to: %s", filePath)
-					default:
-						log.Printf("Unsupported file format: %s", ext)
+					log.Printf("Successfully transcoded screenshot to: %s", filePath)
+				case ".bmp":
+					err = bmp.Encode(dest, img)
+					if err != nil {
+						log.Printf("Error encoding BMP for transcoding: %v", err)
 					}
+					log.Printf("Successfully transcoded screenshot to: %s", filePath)
+				default:
+					log.Printf("Unsupported file format: %s", ext)
 				}
 			}
 		}
+	}
 
-		if Config.PostProcessing.PostCommand != "" {
-			postCmdStr := replaceVariablesInString(Config.PostProcessing.PostCommand, map[string]string{
-				"screenshot":    cacheScreenshot,
-				"wallpaperPath": wallpaperPath,
-				"wallpaperId":   path.Base(wallpaperPath),
-				"volume":        strconv.FormatFloat(volume, 'f', 0, 64),
-				"pid":           strconv.Itoa(pid),
-			})
+	if ctx.Err() != nil {
+		log.Println("Post-processing cancelled, another wallpaper is being applied")
+		return
+	}
 
-			log.Printf("Post-processing command: %s", postCmdStr)
+	if settings.PostCommand != "" {
+		postCmdStr := replaceVariablesInString(settings.PostCommand, map[string]string{
+			"screenshot":    cacheScreenshot,
+			"wallpaperPath": wallpaperPath,
+			"wallpaperId":   path.Base(wallpaperPath),
+			"volume":        strconv.FormatFloat(volume, 'f', 0, 64),
+			"output":        output,
+			"pid":           strconv.Itoa(pid),
+		})
 
-			pid, err := runDetachedProcess("sh", "-c", postCmdStr)
-			if err != nil {
-				log.Printf("Error starting post-processing command '%s': %v", postCmdStr, err)
-			} else {
-				log.Printf("Successfully started post-processing command (PID: %d): %s", pid, postCmdStr)
-			}
-		}
+		log.Printf("Post-processing command: %s", postCmdStr)
 
-		// set swww wallpaper if enabled
-		if Config.PostProcessing.SetSWWW {
-			setSWWW(cacheScreenshot)
+		pid, err := runDetachedProcess("sh", "-c", postCmdStr)
+		if err != nil {
+			log.Printf("Error starting post-processing command '%s': %v", postCmdStr, err)
+		} else {
+			log.Printf("Successfully started post-processing command (PID: %d): %s", pid, postCmdStr)
 		}
 	}
 
-	// Save the last set wallpaper ID
-	Config.SavedUIState.LastSetId = path.Base(wallpaperPath)
-	return nil
+	// set swww wallpaper if enabled
+	if settings.SetSWWW {
+		setSWWW(cacheScreenshot)
+	}
 }
 
 // Runs `swww img <screenshotPath>` to set swww's wallpaper.
@@ -230,46 +591,398 @@ func setSWWW(screenshotPath string) error {
 	return nil
 }
 
-// Restores the last set wallpaper provided from Config.SavedUIState.LastSetId
+// Restores the wallpapers on every connected output.
+//
+// If a profile matches the connected outputs (see connectedProfile), that profile is applied.
+// Otherwise, the last set wallpapers provided from Config.SavedUIState.LastSetIds are restored on every connected configured output.
 //
-// Returns nil if the wallpaper was successfully restored, an error otherwise.
-func restoreWallpaper() error {
-	if Config.SavedUIState.LastSetId == "" {
-		return fmt.Errorf("no last set wallpaper ID found")
+// Returns nil if the wallpapers were successfully restored, an error otherwise.
+// Meant to be run through queueApply, see applyWallpaper.
+func restoreWallpaper(ctx context.Context) error {
+	config := applyConfig(ctx)
+	if profileName, profile, ok := connectedProfile(config); ok {
+		log.Printf("Restoring profile matching the connected outputs: %s", profileName)
+		return applyProfile(ctx, profileName, profile, "")
 	}
 
-	wallpaperPath, err := resolvePath(path.Join(Config.Constants.WallpaperEngineDir, Config.SavedUIState.LastSetId))
-	if err != nil {
-		return fmt.Errorf("failed to resolve wallpaper path: %v", err)
+	outputs := activeOutputs(config)
+	primaryOutput := ""
+	assignments := map[string]ProfileOutputStruct{}
+	for _, output := range outputs {
+		if config.SavedUIState.LastSetIds[output] == "" {
+			continue
+		}
+		if primaryOutput == "" {
+			primaryOutput = output
+		}
+		assignments[output] = ProfileOutputStruct{WallpaperId: config.SavedUIState.LastSetIds[output], Volume: config.SavedUIState.Volume}
+	}
+	if primaryOutput == "" {
+		return fmt.Errorf("no last set wallpaper ID found for any configured output")
 	}
 
-	log.Printf("Restoring last set wallpaper: %s", wallpaperPath)
-	return applyWallpaper(wallpaperPath, float64(Config.SavedUIState.Volume))
+	log.Printf("Restoring last set wallpapers: %v", config.SavedUIState.LastSetIds)
+	if err := applyAssignments(ctx, outputs, assignments, primaryOutput); err != nil {
+		return err
+	}
+	runStateUpdate(func() {
+		Config.SavedUIState.ActiveProfile = ""
+	})
+	return nil
 }
 
-// Applies a random wallpaper from the available wallpapers.
-//
-// Requires WallpaperItems to be populated with available wallpapers.
+// Applies a random wallpaper from the available wallpapers, see pickRandomWallpaper.
 //
 // Returns nil if a random wallpaper was successfully applied, an error otherwise.
-func applyRandomWallpaper() error {
-	if len(WallpaperItems) == 0 {
-		return fmt.Errorf("no wallpapers available to apply")
+// Meant to be run through queueApply, see applyWallpaper.
+func applyRandomWallpaper(ctx context.Context) error {
+	wallpaper, err := pickRandomWallpaper(WallpaperItems)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Applying random wallpaper: %s", wallpaper.WallpaperID)
+	return applyWallpaper(ctx, wallpaper.WallpaperPath, float64(applyConfig(ctx).SavedUIState.Volume), targetOutputs()...)
+}
+
+// Returns a random wallpaper of the items that is not broken, e.g. from WallpaperItems.
+func pickRandomWallpaper(items []WallpaperItem) (WallpaperItem, error) {
+	if len(items) == 0 {
+		return WallpaperItem{}, fmt.Errorf("no wallpapers available to apply")
 	}
 
 	nonBrokenWallpapers := make([]WallpaperItem, 0)
-	for _, item := range WallpaperItems {
+	for _, item := range items {
 		if !item.IsBroken {
 			nonBrokenWallpapers = append(nonBrokenWallpapers, item)
 		}
 	}
 	if len(nonBrokenWallpapers) == 0 {
-		return fmt.Errorf("no non-broken wallpapers available to apply")
+		return WallpaperItem{}, fmt.Errorf("no non-broken wallpapers available to apply")
 	}
 
 	randomIndex := rand.Intn(len(nonBrokenWallpapers))
-	wallpaper := nonBrokenWallpapers[randomIndex]
+	return nonBrokenWallpapers[randomIndex], nil
+}
 
-	log.Printf("Applying random wallpaper: %s", wallpaper.WallpaperID)
-	return applyWallpaper(wallpaper.WallpaperPath, float64(Config.SavedUIState.Volume))
+// Returns the wallpapers in WallpaperItems matching the query, from the most exact kind of match that has any results:
+// the workshop ID, the title ignoring case, the title containing the query, and the title containing the characters
+// of the query in order (e.g. "ngtcty" for "Night City").
+func findWallpapers(query string) []WallpaperItem {
+	query = strings.TrimSpace(query)
+	lowerQuery := strings.ToLower(query)
+	if lowerQuery == "" {
+		return []WallpaperItem{}
+	}
+
+	matchers := []func(item WallpaperItem) bool{
+		func(item WallpaperItem) bool { return item.WallpaperID == query },
+		func(item WallpaperItem) bool { return strings.ToLower(item.projectJson.Title) == lowerQuery },
+		func(item WallpaperItem) bool {
+			return strings.Contains(strings.ToLower(item.projectJson.Title), lowerQuery)
+		},
+		func(item WallpaperItem) bool {
+			return isSubsequence(lowerQuery, strings.ToLower(item.projectJson.Title))
+		},
+	}
+	for _, matches := range matchers {
+		found := []WallpaperItem{}
+		for _, item := range WallpaperItems {
+			if matches(item) {
+				found = append(found, item)
+			}
+		}
+		if len(found) > 0 {
+			return found
+		}
+	}
+	return []WallpaperItem{}
+}
+
+// Returns whether all characters of needle appear in haystack in the same order.
+func isSubsequence(needle string, haystack string) bool {
+	remaining := []rune(needle)
+	for _, char := range haystack {
+		if len(remaining) == 0 {
+			break
+		}
+		if char == remaining[0] {
+			remaining = remaining[1:]
+		}
+	}
+	return len(remaining) == 0
+}
+
+// Helper function to sort the items by Modification Time
+func sortByModTime(items []WallpaperItem, descending bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		iModTime := items[i].ModTime
+		jModTime := items[j].ModTime
+		if iModTime.IsZero() || jModTime.IsZero() {
+			log.Printf("Error getting last modified info for wallpaper %s or %s", items[i].WallpaperID, items[j].WallpaperID)
+			return false // keep original order if there's an error
+		}
+		if descending {
+			return iModTime.After(jModTime)
+		} else {
+			return iModTime.Before(jModTime)
+		}
+	})
+}
+
+// Helper function to sort the items by the title in it's project.json
+//
+// Falls back to WallpaperID if Title is not provided.
+func sortByProjectTitle(items []WallpaperItem, descending bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		iName := items[i].projectJson.Title
+		jName := items[j].projectJson.Title
+		if iName == "" || jName == "" {
+			log.Printf("Error getting name info for wallpaper %s or %s", items[i].WallpaperID, items[j].WallpaperID)
+			return false // keep original order if there's an error
+		}
+		if descending {
+			return iName > jName
+		} else {
+			return iName < jName
+		}
+	})
+}
+
+// Helper function to sort the items by their average CPU usage, see WallpaperStatsMap.
+//
+// Wallpapers without recorded stats are put last, as their usage is unknown.
+func sortByAverageCPU(items []WallpaperItem, descending bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		iStats, iOk := wallpaperStats(items[i].WallpaperID)
+		jStats, jOk := wallpaperStats(items[j].WallpaperID)
+		if !iOk || !jOk {
+			return iOk && !jOk
+		}
+		if descending {
+			return iStats.AverageCPU > jStats.AverageCPU
+		} else {
+			return iStats.AverageCPU < jStats.AverageCPU
+		}
+	})
+}
+
+// Helper function to sort all the WallpaperItems, respecting the config, favorites, and broken, see sortWallpapers.
+func sortWallpaperItems() {
+	sortWallpapers(WallpaperItems, Config.SavedUIState.SortBy)
+}
+
+// Helper function to sort the items, respecting favorites and broken.
+//
+// First sorts all the Wallpapers by sortBy, one of SortModes.
+// Then it sorts them by putting all the Favorites first
+// Finally, it sorts them by putting all the Broken ones last.
+func sortWallpapers(items []WallpaperItem, sortBy string) {
+	switch sortBy {
+	case "date_desc":
+		sortByModTime(items, true)
+	case "date_asc":
+		sortByModTime(items, false)
+	case "name_desc":
+		sortByProjectTitle(items, true)
+	case "name_asc":
+		sortByProjectTitle(items, false)
+	case "cpu_desc":
+		sortByAverageCPU(items, true)
+	case "cpu_asc":
+		sortByAverageCPU(items, false)
+	default:
+		log.Printf("Unknown sort criteria: %s, defaulting to date_desc", sortBy)
+		sortByModTime(items, true)
+	}
+
+	// put favorites first
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].IsFavorite && !items[j].IsFavorite {
+			return true
+		} else {
+			return false
+		}
+	})
+
+	// put broken wallpapers at the end
+	// this is important to do at the end, since a wallpaper can be a favorite and broken
+	// we want to make sure all the broken ones are at the bottom
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].IsBroken && !items[j].IsBroken {
+			return false
+		} else if !items[i].IsBroken && items[j].IsBroken {
+			return true
+		} else {
+			return false
+		}
+	})
+}
+
+// Forces a full refresh of the WallpaperItems.
+//
+// This reads the WallpaperEngineDir (contents directory) to repopulate the WallpaperItems.
+//
+// First it reads the directory and its subdirectories (depth of 1).
+// Each subdirectory is considered a "wallpaper" and the name of the dir is its WallpaperID.
+//
+// Next it reads the project.json in the directory.
+// It parses the JSON for the wallpaper's Title, Description, and Tags.
+// If it fails reading the JSON, or it isn't present, the Title, Description, and Tags are all set to an empty string, "No description available", and empty string array respectively.
+//
+// Then it populates the rest of the WallpaperItem.
+// It adds the ID, cache location for the preview image, checks if its a favorite/broken, and adds the Modification Time.
+//
+// Finally, it adds the WallpaperItem to the global WallpaperItems slice.
+func reloadWallpaperData() error {
+	items, err := scanWallpapers()
+	WallpaperItems = items
+	return err
+}
+
+// Reads the WallpaperEngineDir and returns a WallpaperItem for every wallpaper in it, see reloadWallpaperData.
+//
+// Unlike reloadWallpaperData, it does not change WallpaperItems, so it can be used from the CLI.
+func scanWallpapers() ([]WallpaperItem, error) {
+	items := []WallpaperItem{}
+
+	wallpaperDir, err := ensureDir(Config.Constants.WallpaperEngineDir)
+	if err != nil {
+		return items, fmt.Errorf("failed to ensure wallpaper directory: %v", err)
+	}
+
+	wallpaperFolders, err := os.ReadDir(wallpaperDir)
+	if err != nil {
+		return items, fmt.Errorf("failed to read wallpaper directory: %v", err)
+	}
+
+	if len(wallpaperFolders) == 0 {
+		return items, errors.New("no wallpapers found in the wallpaper directory")
+	}
+
+	for _, wallpaperFolder := range wallpaperFolders {
+		if !wallpaperFolder.IsDir() {
+			log.Printf("Skipping non-directory entry: %s", wallpaperFolder.Name())
+			continue
+		}
+
+		wallpaperPath := path.Join(wallpaperDir, wallpaperFolder.Name())
+
+		projectJsonFilePath := path.Join(wallpaperPath, "project.json")
+		projectJson := ProjectJSON{}
+		data, err := os.ReadFile(projectJsonFilePath)
+		if err != nil {
+			log.Printf("Error reading project.json for wallpaper %s: %v", wallpaperFolder.Name(), err)
+			continue
+		}
+
+		err = json.Unmarshal(data, &projectJson)
+		if err != nil {
+			log.Printf("Error reading project.json for wallpaper %s: %v", wallpaperFolder.Name(), err)
+			projectJson = ProjectJSON{
+				Title:        wallpaperFolder.Name(),
+				Description:  "No description available",
+				Tags:         []string{},
+				PreviewImage: "",
+			}
+		}
+		projectJson.Properties = parseProjectProperties(data)
+
+		var cachedImagePath string
+		if projectJson.PreviewImage == "" {
+			cachedImagePath = ""
+		} else {
+			cachedImagePath = path.Join(CacheDir, wallpaperFolder.Name(), projectJson.PreviewImage)
+		}
+
+		var modTime time.Time
+		info, err := wallpaperFolder.Info()
+		if err != nil {
+			log.Printf("Error getting info for wallpaper %s: %v", wallpaperFolder.Name(), err)
+			modTime = time.Time{} // default to zero value if we cannot get the mod time
+		} else {
+			modTime = info.ModTime()
+		}
+
+		items = append(items, WallpaperItem{
+			projectJson:   projectJson,
+			WallpaperID:   wallpaperFolder.Name(),
+			WallpaperPath: wallpaperPath,
+			CachedPath:    cachedImagePath,
+			IsFavorite:    slices.Contains(Config.SavedUIState.Favorites, wallpaperFolder.Name()),
+			IsBroken:      slices.Contains(Config.SavedUIState.Broken, wallpaperFolder.Name()),
+			ModTime:       modTime,
+		})
+	}
+
+	return items, nil
+}
+
+// Returns whether the title, description or one of the tags of the wallpaper contains the query, ignoring case.
+func matchesSearch(item WallpaperItem, query string) bool {
+	query = strings.ToLower(query)
+	if strings.Contains(strings.ToLower(item.projectJson.Title), query) || strings.Contains(strings.ToLower(item.projectJson.Description), query) {
+		return true
+	}
+	for _, tag := range item.projectJson.Tags {
+		if strings.Contains(strings.ToLower(tag), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filters for the wallpapers listed or picked from the CLI, see filterWallpaperItems. Empty fields do not filter.
+type WallpaperFilter struct {
+	FavoritesOnly bool
+	BrokenOnly    bool
+	Tags          []string // the wallpaper needs every tag, ignoring case
+	Type          string   // the type in project.json, ignoring case, e.g. "scene"
+	Search        string   // see matchesSearch
+	ExcludeIds    []string
+}
+
+// Returns the items that match the filter, keeping their order.
+func filterWallpaperItems(items []WallpaperItem, filter WallpaperFilter) []WallpaperItem {
+	filtered := []WallpaperItem{}
+	for _, item := range items {
+		if filter.FavoritesOnly && !item.IsFavorite {
+			continue
+		}
+		if filter.BrokenOnly && !item.IsBroken {
+			continue
+		}
+		hasTags := true
+		for _, tag := range filter.Tags {
+			hasTags = hasTags && slices.ContainsFunc(item.projectJson.Tags, func(itemTag string) bool { return strings.EqualFold(itemTag, tag) })
+		}
+		if !hasTags {
+			continue
+		}
+		if filter.Search != "" && !matchesSearch(item, filter.Search) {
+			continue
+		}
+		if filter.Type != "" && !strings.EqualFold(item.projectJson.Type, filter.Type) {
+			continue
+		}
+		if slices.Contains(filter.ExcludeIds, item.WallpaperID) {
+			continue
+		}
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
+
+// Returns the path of the thumbnail of the wallpaper in the cache directory, created by loadImageAsync.
+func thumbnailCachePath(wallpaperId string) string {
+	return path.Join(CacheDir, wallpaperId, "thumbnail.png")
+}
+
+// Returns the IDs of the n most recently applied wallpapers, newest first, from Config.SavedUIState.LastApplied.
+func recentlyAppliedIds(n int) []string {
+	ids := slices.Collect(maps.Keys(Config.SavedUIState.LastApplied))
+	slices.SortFunc(ids, func(a, b string) int {
+		return Config.SavedUIState.LastApplied[b].Compare(Config.SavedUIState.LastApplied[a])
+	})
+	return ids[:min(max(n, 0), len(ids))]
 }